@@ -203,16 +203,23 @@ var file_redirect_prompt_proto_rawDesc = []byte{
 	0x72, 0x6f, 0x72, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0e,
 	0x67, 0x65, 0x6e, 0x41, 0x69, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x18,
 	0x0a, 0x07, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0c, 0x52,
-	0x07, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x32, 0x75, 0x0a, 0x0e, 0x41, 0x69, 0x50, 0x72,
-	0x6f, 0x78, 0x79, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x63, 0x0a, 0x0e, 0x52, 0x65,
-	0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x12, 0x26, 0x2e, 0x72,
-	0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x2e, 0x52,
-	0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x1a, 0x27, 0x2e, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f,
-	0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50,
-	0x72, 0x6f, 0x6d, 0x70, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42,
-	0x15, 0x5a, 0x13, 0x61, 0x70, 0x69, 0x2f, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f,
-	0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x07, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x32, 0xe2, 0x01, 0x0a, 0x0e, 0x41, 0x69, 0x50,
+	0x72, 0x6f, 0x78, 0x79, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x63, 0x0a, 0x0e, 0x52,
+	0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x12, 0x26, 0x2e,
+	0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x2e,
+	0x52, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x27, 0x2e, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74,
+	0x5f, 0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74,
+	0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x12, 0x6b, 0x0a, 0x14, 0x52, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d,
+	0x70, 0x74, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x26, 0x2e, 0x72, 0x65, 0x64, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x2e, 0x52, 0x65, 0x64, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x27, 0x2e, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f, 0x70, 0x72, 0x6f, 0x6d,
+	0x70, 0x74, 0x2e, 0x52, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x50, 0x72, 0x6f, 0x6d, 0x70,
+	0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x30, 0x01, 0x42, 0x15, 0x5a,
+	0x13, 0x61, 0x70, 0x69, 0x2f, 0x72, 0x65, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x5f, 0x70, 0x72,
+	0x6f, 0x6d, 0x70, 0x74, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -234,9 +241,11 @@ var file_redirect_prompt_proto_goTypes = []interface{}{
 }
 var file_redirect_prompt_proto_depIdxs = []int32{
 	0, // 0: redirect_prompt.AiProxyService.RedirectPrompt:input_type -> redirect_prompt.RedirectPromptRequest
-	1, // 1: redirect_prompt.AiProxyService.RedirectPrompt:output_type -> redirect_prompt.RedirectPromptResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
+	0, // 1: redirect_prompt.AiProxyService.RedirectPromptStream:input_type -> redirect_prompt.RedirectPromptRequest
+	1, // 2: redirect_prompt.AiProxyService.RedirectPrompt:output_type -> redirect_prompt.RedirectPromptResponse
+	1, // 3: redirect_prompt.AiProxyService.RedirectPromptStream:output_type -> redirect_prompt.RedirectPromptResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -306,6 +315,8 @@ const _ = grpc.SupportPackageIsVersion6
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type AiProxyServiceClient interface {
 	RedirectPrompt(ctx context.Context, in *RedirectPromptRequest, opts ...grpc.CallOption) (*RedirectPromptResponse, error)
+	// Each response content holds one chat completion chunk, errors are reported through gen_ai_error_code
+	RedirectPromptStream(ctx context.Context, in *RedirectPromptRequest, opts ...grpc.CallOption) (AiProxyService_RedirectPromptStreamClient, error)
 }
 
 type aiProxyServiceClient struct {
@@ -325,9 +336,43 @@ func (c *aiProxyServiceClient) RedirectPrompt(ctx context.Context, in *RedirectP
 	return out, nil
 }
 
+func (c *aiProxyServiceClient) RedirectPromptStream(ctx context.Context, in *RedirectPromptRequest, opts ...grpc.CallOption) (AiProxyService_RedirectPromptStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_AiProxyService_serviceDesc.Streams[0], "/redirect_prompt.AiProxyService/RedirectPromptStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &aiProxyServiceRedirectPromptStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AiProxyService_RedirectPromptStreamClient interface {
+	Recv() (*RedirectPromptResponse, error)
+	grpc.ClientStream
+}
+
+type aiProxyServiceRedirectPromptStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *aiProxyServiceRedirectPromptStreamClient) Recv() (*RedirectPromptResponse, error) {
+	m := new(RedirectPromptResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // AiProxyServiceServer is the server API for AiProxyService service.
 type AiProxyServiceServer interface {
 	RedirectPrompt(context.Context, *RedirectPromptRequest) (*RedirectPromptResponse, error)
+	// Each response content holds one chat completion chunk, errors are reported through gen_ai_error_code
+	RedirectPromptStream(*RedirectPromptRequest, AiProxyService_RedirectPromptStreamServer) error
 }
 
 // UnimplementedAiProxyServiceServer can be embedded to have forward compatible implementations.
@@ -337,6 +382,9 @@ type UnimplementedAiProxyServiceServer struct {
 func (*UnimplementedAiProxyServiceServer) RedirectPrompt(context.Context, *RedirectPromptRequest) (*RedirectPromptResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RedirectPrompt not implemented")
 }
+func (*UnimplementedAiProxyServiceServer) RedirectPromptStream(*RedirectPromptRequest, AiProxyService_RedirectPromptStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method RedirectPromptStream not implemented")
+}
 
 func RegisterAiProxyServiceServer(s *grpc.Server, srv AiProxyServiceServer) {
 	s.RegisterService(&_AiProxyService_serviceDesc, srv)
@@ -360,6 +408,27 @@ func _AiProxyService_RedirectPrompt_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AiProxyService_RedirectPromptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RedirectPromptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AiProxyServiceServer).RedirectPromptStream(m, &aiProxyServiceRedirectPromptStreamServer{stream})
+}
+
+type AiProxyService_RedirectPromptStreamServer interface {
+	Send(*RedirectPromptResponse) error
+	grpc.ServerStream
+}
+
+type aiProxyServiceRedirectPromptStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *aiProxyServiceRedirectPromptStreamServer) Send(m *RedirectPromptResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _AiProxyService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "redirect_prompt.AiProxyService",
 	HandlerType: (*AiProxyServiceServer)(nil),
@@ -369,6 +438,12 @@ var _AiProxyService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _AiProxyService_RedirectPrompt_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RedirectPromptStream",
+			Handler:       _AiProxyService_RedirectPromptStream_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "redirect_prompt.proto",
 }
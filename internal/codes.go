@@ -7,3 +7,5 @@ const errorCodeMaxTokens = "context_length_exceeded"
 /* Finish reasons */
 
 const FinishReasonLength = "length"
+
+const FinishReasonContentFilter = "content_filter"
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSConfig secures the connection to the grpc proxy, the zero value verifies the server with the system roots
+type TLSConfig struct {
+	// CAFile or CAPEM hold the PEM bundle verifying the server instead of the system roots
+	CAFile string
+	CAPEM  []byte
+	// CertFile and KeyFile, or CertPEM and KeyPEM, hold the client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	CertPEM  []byte
+	KeyPEM   []byte
+	// ServerName overrides the name verified in the server certificate
+	ServerName string
+}
+
+func (c *TLSConfig) transportCredentials() (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+
+	caPEM := c.CAPEM
+	if c.CAFile != "" {
+		var err error
+		caPEM, err = os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in the CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	switch {
+	case c.CertFile != "" || c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case len(c.CertPEM) > 0 || len(c.KeyPEM) > 0:
+		cert, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// dialOptions returns the transport and per-RPC credentials of the config, plain text when no TLS is configured
+func (c Config) dialOptions() ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+	if c.TLS != nil {
+		var err error
+		creds, err = c.TLS.transportCredentials()
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c.PerRPCCredentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(c.PerRPCCredentials))
+	}
+	return opts, nil
+}
+
+// BearerToken sends the token in the authorization metadata of every call, only over TLS
+type BearerToken string
+
+func (t BearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t BearerToken) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/internal/api/redirect_prompt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestCA(t *testing.T, name string) *testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+}
+
+type authProxyServer struct {
+	fakeProxyServer
+	authorization string
+}
+
+func (s *authProxyServer) RedirectPrompt(ctx context.Context, req *redirect_prompt.RedirectPromptRequest) (*redirect_prompt.RedirectPromptResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		s.authorization = values[0]
+	}
+	return s.fakeProxyServer.RedirectPrompt(ctx, req)
+}
+
+func newTLSBufconnWrapper(t *testing.T, srv redirect_prompt.AiProxyServiceServer, serverTLS *tls.Config, config Config) Wrapper {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	redirect_prompt.RegisterAiProxyServiceServer(server, srv)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	wrapper, err := NewWrapperInternalImpl("passthrough:///bufnet", 4, config, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = wrapper.Close()
+	})
+	return wrapper
+}
+
+func TestInternalCallMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	serverCert := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "proxy.test"},
+		DNSNames:     []string{"proxy.test"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCert := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "gen-ai-wrapper"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	serverKeyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverTLS := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	srv := &authProxyServer{}
+	wrapper := newTLSBufconnWrapper(t, srv, serverTLS, Config{
+		TLS: &TLSConfig{
+			CAPEM:      ca.certPEM,
+			CertPEM:    clientCert.certPEM,
+			KeyPEM:     clientCert.keyPEM,
+			ServerName: "proxy.test",
+		},
+		PerRPCCredentials: BearerToken("secret-token"),
+	})
+	response, err := wrapper.CallContext(context.Background(), newTestRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Choices[0].Message.Content != "unary" {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+	if srv.authorization != "Bearer secret-token" {
+		t.Fatalf("Expected the bearer token in the metadata, got %q", srv.authorization)
+	}
+
+	failing := map[string]TLSConfig{
+		"without client certificate": {CAPEM: ca.certPEM, ServerName: "proxy.test"},
+		"with unknown CA":            {CAPEM: newTestCA(t, "other ca").certPEM, CertPEM: clientCert.certPEM, KeyPEM: clientCert.keyPEM, ServerName: "proxy.test"},
+		"with wrong server name":     {CAPEM: ca.certPEM, CertPEM: clientCert.certPEM, KeyPEM: clientCert.keyPEM, ServerName: "other.test"},
+	}
+	for name, tlsConfig := range failing {
+		tlsConfig := tlsConfig
+		wrapper := newTLSBufconnWrapper(t, &authProxyServer{}, serverTLS, Config{TLS: &tlsConfig})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := wrapper.CallContext(ctx, newTestRequest())
+		cancel()
+		if err == nil {
+			t.Errorf("Expected the call %s to fail", name)
+		}
+	}
+}
+
+func TestBearerTokenRequiresTLS(t *testing.T) {
+	_, err := NewWrapperInternalImpl("localhost:50051", 4, Config{PerRPCCredentials: BearerToken("secret-token")})
+	if err == nil {
+		t.Fatal("Expected the token not to be sent over plain text")
+	}
+}
+
+func TestTLSConfigInvalidCA(t *testing.T) {
+	_, err := NewWrapperInternalImpl("localhost:443", 4, Config{TLS: &TLSConfig{CAPEM: []byte("not a certificate")}})
+	if err == nil {
+		t.Fatal("Expected an invalid CA bundle error")
+	}
+}
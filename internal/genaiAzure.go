@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultAzureAPIVersion = "2024-10-21"
+
+const azureAPIKeyHeader = "api-key"
+
+// AzureConfig selects the Azure OpenAI deployment serving the calls
+type AzureConfig struct {
+	Deployment string
+	APIVersion string
+}
+
+// NewAzureWrapperImpl calls an Azure OpenAI deployment, endPoint is the resource endpoint,
+// e.g. https://my-resource.openai.azure.com, or the full chat completions URL of the deployment
+func NewAzureWrapperImpl(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	azure := AzureConfig{Deployment: config.Model}
+	if config.Azure != nil {
+		azure = *config.Azure
+	}
+	if azure.Deployment == "" {
+		azure.Deployment = config.Model
+	}
+	deploymentURL, err := azureDeploymentURL(endPoint, &azure)
+	if err != nil {
+		return nil, err
+	}
+	return &WrapperImpl{
+		client:       http.DefaultClient,
+		endPoint:     deploymentURL,
+		apiKey:       apiKey,
+		apiKeyHeader: azureAPIKeyHeader,
+		dropLen:      dropLen,
+		retryPolicy:  config.RetryPolicy,
+	}, nil
+}
+
+func azureDeploymentURL(endPoint string, azure *AzureConfig) (string, error) {
+	endPointURL, err := url.Parse(endPoint)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(endPointURL.Path, "/chat/completions") {
+		// the deployment is a single segment of the path, whatever its characters
+		rawPath := strings.TrimSuffix(endPointURL.EscapedPath(), "/") + "/openai/deployments/" + url.PathEscape(azure.Deployment) + "/chat/completions"
+		if endPointURL.Path, err = url.PathUnescape(rawPath); err != nil {
+			return "", err
+		}
+		endPointURL.RawPath = rawPath
+	}
+	query := endPointURL.Query()
+	if query.Get("api-version") == "" {
+		apiVersion := azure.APIVersion
+		if apiVersion == "" {
+			apiVersion = DefaultAzureAPIVersion
+		}
+		query.Set("api-version", apiVersion)
+	}
+	endPointURL.RawQuery = query.Encode()
+	return endPointURL.String(), nil
+}
@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+func TestAzureDeploymentURL(t *testing.T) {
+	cases := []struct {
+		endPoint string
+		azure    AzureConfig
+		expected string
+	}{
+		{"https://res.openai.azure.com", AzureConfig{Deployment: "gpt-4"},
+			"https://res.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=" + DefaultAzureAPIVersion},
+		{"https://res.openai.azure.com/", AzureConfig{Deployment: "gpt-4", APIVersion: "2024-06-01"},
+			"https://res.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2024-06-01"},
+		{"https://res.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15", AzureConfig{Deployment: "other"},
+			"https://res.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15"},
+		{"https://res.openai.azure.com", AzureConfig{Deployment: "team/gpt-4?x=1%"},
+			"https://res.openai.azure.com/openai/deployments/team%2Fgpt-4%3Fx=1%25/chat/completions?api-version=" + DefaultAzureAPIVersion},
+		{"https://gateway.example.com/azure%2Fopenai", AzureConfig{Deployment: "gpt 4"},
+			"https://gateway.example.com/azure%2Fopenai/openai/deployments/gpt%204/chat/completions?api-version=" + DefaultAzureAPIVersion},
+	}
+	for _, c := range cases {
+		actual, err := azureDeploymentURL(c.endPoint, &c.azure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != c.expected {
+			t.Errorf("azureDeploymentURL(%q) = %q, expected %q", c.endPoint, actual, c.expected)
+		}
+	}
+}
@@ -2,26 +2,33 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
-	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
 	"io"
 	"net/http"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
 )
 
 type WrapperImpl struct {
+	client        *http.Client
 	apiKey        string
+	apiKeyHeader  string
 	endPoint      string
 	dropLen       int
+	retryPolicy   retry.Policy
 	setupMessages []message.Message
 }
 
-func NewWrapperImpl(endPoint, apiKey string, dropLen int) Wrapper {
+func NewWrapperImpl(endPoint, apiKey string, dropLen int, config Config) Wrapper {
 	return &WrapperImpl{
-		endPoint: endPoint,
-		apiKey:   apiKey,
-		dropLen:  dropLen,
+		client:      http.DefaultClient,
+		endPoint:    endPoint,
+		apiKey:      apiKey,
+		dropLen:     dropLen,
+		retryPolicy: config.RetryPolicy,
 	}
 }
 
@@ -30,25 +37,57 @@ func (w *WrapperImpl) SetupCall(messages []message.Message) {
 }
 
 func (w *WrapperImpl) Call(requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
-	if w.setupMessages != nil {
-		//true for GPT4
-		if requestBody.Model == models.GPT4 {
-			requestBody.Messages = append(w.setupMessages, requestBody.Messages...)
-		} else {
-			userIndex := findLastUserIndex(requestBody.Messages)
-			front := requestBody.Messages[:userIndex]
-			back := requestBody.Messages[userIndex:]
-			requestBody.Messages = append(front, w.setupMessages...)
-			requestBody.Messages = append(requestBody.Messages, back...)
-		}
+	return w.CallContext(context.Background(), requestBody)
+}
+
+func (w *WrapperImpl) CallContext(ctx context.Context, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return w.call(ctx, withSetupMessages(w.setupMessages, requestBody))
+}
+
+func (w *WrapperImpl) call(ctx context.Context, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return withRetry(ctx, w.retryPolicy, func() (*ChatCompletionResponse, error) {
+		return w.send(ctx, requestBody)
+	})
+}
+
+func (w *WrapperImpl) send(ctx context.Context, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	req, err := w.prepareRequest(ctx, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 
-	req, err := w.prepareRequest(requestBody)
+	return w.handleGptResponse(ctx, requestBody, resp)
+}
+
+func (w *WrapperImpl) CallStream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	requestBody = withSetupMessages(w.setupMessages, requestBody)
+	requestBody.Stream = true
+	requestBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	return w.stream(ctx, requestBody, onChunk)
+}
+
+func (w *WrapperImpl) stream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	return withRetry(ctx, w.retryPolicy, func() (*ChatCompletionResponse, error) {
+		return w.sendStream(ctx, requestBody, onChunk)
+	})
+}
+
+func (w *WrapperImpl) sendStream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	req, err := w.prepareRequest(ctx, requestBody)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Accept", "text/event-stream")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := w.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -56,30 +95,59 @@ func (w *WrapperImpl) Call(requestBody ChatCompletionRequest) (*ChatCompletionRe
 		_ = resp.Body.Close()
 	}()
 
-	return w.handleGptResponse(requestBody, resp)
+	if resp.StatusCode == http.StatusOK {
+		delivered := false
+		response, err := readChunks(resp.Body, func(chunk *ChatCompletionChunk) error {
+			delivered = true
+			if onChunk != nil {
+				return onChunk(chunk)
+			}
+			return nil
+		})
+		if err != nil && delivered {
+			// replaying the request would deliver the same chunks twice
+			return nil, &permanentError{err}
+		}
+		return response, err
+	}
+	errorResponse, err := w.readErrorResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		if messages, ok := w.dropOnMaxTokens(errorResponse, requestBody); ok {
+			requestBody.Messages = messages
+			return w.sendStream(ctx, requestBody, onChunk)
+		}
+	}
+	return nil, fromResponse(resp.StatusCode, errorResponse, retryAfterFromHeader(resp.StatusCode, resp.Header))
 }
 
-func (w *WrapperImpl) prepareRequest(requestBody ChatCompletionRequest) (*http.Request, error) {
+func (w *WrapperImpl) prepareRequest(ctx context.Context, requestBody ChatCompletionRequest) (*http.Request, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, w.endPoint, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endPoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", w.apiKey))
+	if w.apiKeyHeader != "" {
+		req.Header.Set(w.apiKeyHeader, w.apiKey)
+	} else {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", w.apiKey))
+	}
 	return req, nil
 }
 
-func (w *WrapperImpl) handleGptResponse(requestBody ChatCompletionRequest, resp *http.Response) (*ChatCompletionResponse, error) {
-	var err error
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+func (w *WrapperImpl) handleGptResponse(ctx context.Context, requestBody ChatCompletionRequest, resp *http.Response) (*ChatCompletionResponse, error) {
 	if resp.StatusCode == http.StatusOK {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
 		var responseBody = new(ChatCompletionResponse)
 		err = json.Unmarshal(bodyBytes, responseBody)
 		if err != nil {
@@ -87,21 +155,34 @@ func (w *WrapperImpl) handleGptResponse(requestBody ChatCompletionRequest, resp
 		}
 		return responseBody, nil
 	}
-	var errorResponse = new(ErrorResponse)
-	err = json.Unmarshal(bodyBytes, errorResponse)
+	errorResponse, err := w.readErrorResponse(resp)
 	if err != nil {
 		return nil, err
 	}
 	switch resp.StatusCode {
 	case http.StatusBadRequest:
-		if errorResponse.Error.Code == errorCodeMaxTokens {
-			return w.Call(ChatCompletionRequest{
-				Model:    requestBody.Model,
-				Messages: requestBody.Messages[w.dropLen:],
-			})
+		if messages, ok := w.dropOnMaxTokens(errorResponse, requestBody); ok {
+			requestBody.Messages = messages
+			// resend within the attempt, the retry loop of the call already wraps it
+			return w.send(ctx, requestBody)
 		}
 	}
-	return nil, fromResponse(resp.StatusCode, errorResponse)
+	return nil, fromResponse(resp.StatusCode, errorResponse, retryAfterFromHeader(resp.StatusCode, resp.Header))
+}
+
+func (w *WrapperImpl) dropOnMaxTokens(errorResponse *ErrorResponse, requestBody ChatCompletionRequest) ([]message.Message, bool) {
+	if errorResponse.Error.Code != errorCodeMaxTokens {
+		return nil, false
+	}
+	return DropOldest(requestBody.Messages, w.dropLen, findLastUserIndex(requestBody.Messages))
+}
+
+func (w *WrapperImpl) readErrorResponse(resp *http.Response) (*ErrorResponse, error) {
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeErrorResponse(resp.StatusCode, bodyBytes), nil
 }
 
 func (w *WrapperImpl) Close() error {
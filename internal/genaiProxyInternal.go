@@ -6,31 +6,41 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/Checkmarx/gen-ai-wrapper/internal/api/redirect_prompt"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
-	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type WrapperInternalImpl struct {
 	connection    *grpc.ClientConn
 	client        redirect_prompt.AiProxyServiceClient
 	dropLen       int
+	retryPolicy   retry.Policy
 	setupMessages []message.Message
 }
 
-func NewWrapperInternalImpl(endPoint string, dropLen int) (Wrapper, error) {
-	connection, err := grpc.NewClient(endPoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewWrapperInternalImpl(endPoint string, dropLen int, config Config, opts ...grpc.DialOption) (Wrapper, error) {
+	credentialOpts, err := config.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(credentialOpts, opts...)
+	connection, err := grpc.NewClient(endPoint, opts...)
 	if err != nil {
 		return nil, err
 	}
 	client := redirect_prompt.NewAiProxyServiceClient(connection)
 	return &WrapperInternalImpl{
-		connection: connection,
-		client:     client,
-		dropLen:    dropLen,
+		connection:  connection,
+		client:      client,
+		dropLen:     dropLen,
+		retryPolicy: config.RetryPolicy,
 	}, nil
 }
 
@@ -39,29 +49,132 @@ func (w *WrapperInternalImpl) SetupCall(messages []message.Message) {
 }
 
 func (w *WrapperInternalImpl) Call(requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
-	if w.setupMessages != nil {
-		//true for GPT4
-		if requestBody.Model == models.GPT4 {
-			requestBody.Messages = append(w.setupMessages, requestBody.Messages...)
-		} else {
-			userIndex := findLastUserIndex(requestBody.Messages)
-			front := requestBody.Messages[:userIndex]
-			back := requestBody.Messages[userIndex:]
-			requestBody.Messages = append(front, w.setupMessages...)
-			requestBody.Messages = append(requestBody.Messages, back...)
-		}
+	return w.CallContext(context.Background(), requestBody)
+}
+
+func (w *WrapperInternalImpl) CallContext(ctx context.Context, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return w.call(EnsureRequestID(ctx), withSetupMessages(w.setupMessages, requestBody))
+}
+
+func (w *WrapperInternalImpl) call(ctx context.Context, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	req, err := w.prepareRequest(MetaDataFromContext(ctx), requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return withRetry(ctx, w.retryPolicy, func() (*ChatCompletionResponse, error) {
+		return w.send(ctx, requestBody, req)
+	})
+}
+
+func (w *WrapperInternalImpl) send(ctx context.Context, requestBody ChatCompletionRequest,
+	req *redirect_prompt.RedirectPromptRequest) (*ChatCompletionResponse, error) {
+	var header, trailer metadata.MD
+	resp, err := w.client.RedirectPrompt(ctx, req, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, withRetryAfter(err, retryAfterFromMetadata(http.StatusTooManyRequests, header, trailer))
 	}
+	return w.handleGptResponse(ctx, requestBody, resp, retryAfterFromMetadata(int(resp.GenAiErrorCode), header, trailer))
+}
 
-	req, err := w.prepareRequest(ChatMetaData{}, requestBody)
+func (w *WrapperInternalImpl) CallStream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	requestBody = withSetupMessages(w.setupMessages, requestBody)
+	requestBody.Stream = true
+	requestBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	return w.stream(EnsureRequestID(ctx), requestBody, onChunk)
+}
+
+func (w *WrapperInternalImpl) stream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	req, err := w.prepareRequest(MetaDataFromContext(ctx), requestBody)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := w.client.RedirectPrompt(context.Background(), req)
+	return withRetry(ctx, w.retryPolicy, func() (*ChatCompletionResponse, error) {
+		return w.receiveStream(ctx, requestBody, req, onChunk)
+	})
+}
+
+func (w *WrapperInternalImpl) receiveStream(ctx context.Context, requestBody ChatCompletionRequest,
+	req *redirect_prompt.RedirectPromptRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	stream, err := w.client.RedirectPromptStream(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	return w.handleGptResponse(requestBody, resp)
+
+	accumulator := newChunkAccumulator()
+	received := false
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !received && status.Code(err) == codes.Unimplemented {
+				return w.callUnaryAsStream(ctx, requestBody, onChunk)
+			}
+			if received {
+				// replaying the request would deliver the same chunks twice
+				return nil, &permanentError{err}
+			}
+			return nil, withRetryAfter(err, retryAfterFromMetadata(http.StatusTooManyRequests, stream.Trailer()))
+		}
+		if resp.GenAiErrorCode != http.StatusOK && !received {
+			header, _ := stream.Header()
+			return w.handleStreamError(ctx, requestBody, resp, onChunk, retryAfterFromMetadata(int(resp.GenAiErrorCode), header))
+		}
+		received = true
+		if resp.GenAiErrorCode != http.StatusOK {
+			return nil, &permanentError{fromResponse(int(resp.GenAiErrorCode), decodeErrorResponse(int(resp.GenAiErrorCode), resp.Content), 0)}
+		}
+		if string(resp.Content) == streamDone {
+			break
+		}
+		chunk, err := decodeChunk(resp.Content)
+		if err != nil {
+			return nil, &permanentError{err}
+		}
+		err = accumulator.add(chunk, onChunk)
+		if err != nil {
+			return nil, &permanentError{err}
+		}
+	}
+	return accumulator.response(), nil
+}
+
+// callUnaryAsStream serves proxies without streaming support by reporting the whole response as a single chunk
+func (w *WrapperInternalImpl) callUnaryAsStream(ctx context.Context, requestBody ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	requestBody.Stream = false
+	requestBody.StreamOptions = nil
+	response, err := w.call(ctx, requestBody)
+	if err != nil {
+		// the unary call was already retried
+		return nil, &permanentError{err}
+	}
+	if onChunk != nil {
+		err = onChunk(singleChunk(response))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (w *WrapperInternalImpl) handleStreamError(ctx context.Context, requestBody ChatCompletionRequest,
+	resp *redirect_prompt.RedirectPromptResponse, onChunk ChunkHandler, retryAfter time.Duration) (*ChatCompletionResponse, error) {
+	errorResponse := decodeErrorResponse(int(resp.GenAiErrorCode), resp.Content)
+	switch resp.GenAiErrorCode {
+	case http.StatusBadRequest:
+		if messages, ok := w.dropOnMaxTokens(errorResponse, requestBody); ok {
+			requestBody.Messages = messages
+			req, err := w.prepareRequest(MetaDataFromContext(ctx), requestBody)
+			if err != nil {
+				return nil, err
+			}
+			return w.receiveStream(ctx, requestBody, req, onChunk)
+		}
+	}
+	return nil, fromResponse(int(resp.GenAiErrorCode), errorResponse, retryAfter)
 }
 
 func (w *WrapperInternalImpl) prepareRequest(metaData ChatMetaData, requestBody ChatCompletionRequest) (*redirect_prompt.RedirectPromptRequest, error) {
@@ -78,7 +191,8 @@ func (w *WrapperInternalImpl) prepareRequest(metaData ChatMetaData, requestBody
 	return req, nil
 }
 
-func (w *WrapperInternalImpl) handleGptResponse(requestBody ChatCompletionRequest, resp *redirect_prompt.RedirectPromptResponse) (*ChatCompletionResponse, error) {
+func (w *WrapperInternalImpl) handleGptResponse(ctx context.Context, requestBody ChatCompletionRequest,
+	resp *redirect_prompt.RedirectPromptResponse, retryAfter time.Duration) (*ChatCompletionResponse, error) {
 	var err error
 	bodyBytes, err := io.ReadAll(bytes.NewBuffer(resp.Content))
 	if err != nil {
@@ -92,21 +206,27 @@ func (w *WrapperInternalImpl) handleGptResponse(requestBody ChatCompletionReques
 		}
 		return responseBody, nil
 	}
-	var errorResponse = new(ErrorResponse)
-	err = json.Unmarshal(bodyBytes, errorResponse)
-	if err != nil {
-		return nil, err
-	}
+	errorResponse := decodeErrorResponse(int(resp.GenAiErrorCode), bodyBytes)
 	switch resp.GenAiErrorCode {
 	case http.StatusBadRequest:
-		if errorResponse.Error.Code == errorCodeMaxTokens {
-			return w.Call(ChatCompletionRequest{
-				Model:    requestBody.Model,
-				Messages: requestBody.Messages[w.dropLen:],
-			})
+		if messages, ok := w.dropOnMaxTokens(errorResponse, requestBody); ok {
+			requestBody.Messages = messages
+			req, err := w.prepareRequest(MetaDataFromContext(ctx), requestBody)
+			if err != nil {
+				return nil, err
+			}
+			// resend within the attempt, the retry loop of the call already wraps it
+			return w.send(ctx, requestBody, req)
 		}
 	}
-	return nil, fromResponse(int(resp.GenAiErrorCode), errorResponse)
+	return nil, fromResponse(int(resp.GenAiErrorCode), errorResponse, retryAfter)
+}
+
+func (w *WrapperInternalImpl) dropOnMaxTokens(errorResponse *ErrorResponse, requestBody ChatCompletionRequest) ([]message.Message, bool) {
+	if errorResponse.Error.Code != errorCodeMaxTokens {
+		return nil, false
+	}
+	return DropOldest(requestBody.Messages, w.dropLen, findLastUserIndex(requestBody.Messages))
 }
 
 func (w *WrapperInternalImpl) Close() error {
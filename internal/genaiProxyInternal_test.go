@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/internal/api/redirect_prompt"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeProxyServer struct {
+	redirect_prompt.UnimplementedAiProxyServiceServer
+	chunks   []string
+	block    bool
+	failures int
+	// maxMessages makes longer requests fail as exceeding the context length
+	maxMessages int
+	request     *ChatCompletionRequest
+	received    []*redirect_prompt.RedirectPromptRequest
+}
+
+func (s *fakeProxyServer) RedirectPrompt(ctx context.Context, req *redirect_prompt.RedirectPromptRequest) (*redirect_prompt.RedirectPromptResponse, error) {
+	s.received = append(s.received, req)
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.maxMessages > 0 {
+		var request ChatCompletionRequest
+		if err := json.Unmarshal(req.Content, &request); err != nil {
+			return nil, err
+		}
+		if len(request.Messages) > s.maxMessages {
+			return &redirect_prompt.RedirectPromptResponse{
+				GenAiErrorCode: http.StatusBadRequest,
+				Content:        []byte(`{"error":{"message":"maximum context length","code":"context_length_exceeded"}}`),
+			}, nil
+		}
+	}
+	if s.failures > 0 {
+		s.failures--
+		return &redirect_prompt.RedirectPromptResponse{
+			GenAiErrorCode: http.StatusServiceUnavailable,
+			Content:        []byte(`{"error":{"message":"The engine is currently overloaded","type":"server_error"}}`),
+		}, nil
+	}
+	return &redirect_prompt.RedirectPromptResponse{
+		GenAiErrorCode: http.StatusOK,
+		Content:        []byte(`{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"unary"},"finish_reason":"stop"}]}`),
+	}, nil
+}
+
+func (s *fakeProxyServer) RedirectPromptStream(req *redirect_prompt.RedirectPromptRequest, stream redirect_prompt.AiProxyService_RedirectPromptStreamServer) error {
+	if s.chunks == nil {
+		return status.Error(codes.Unimplemented, "not implemented")
+	}
+	s.request = new(ChatCompletionRequest)
+	if err := json.Unmarshal(req.Content, s.request); err != nil {
+		return err
+	}
+	for _, chunk := range s.chunks {
+		err := stream.Send(&redirect_prompt.RedirectPromptResponse{
+			GenAiErrorCode: http.StatusOK,
+			Content:        []byte(chunk),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newBufconnWrapper(t *testing.T, srv redirect_prompt.AiProxyServiceServer) Wrapper {
+	return newBufconnWrapperWithConfig(t, srv, Config{})
+}
+
+func newBufconnWrapperWithConfig(t *testing.T, srv redirect_prompt.AiProxyServiceServer, config Config) Wrapper {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	redirect_prompt.RegisterAiProxyServiceServer(server, srv)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	wrapper, err := NewWrapperInternalImpl("passthrough:///bufnet", 4, config, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = wrapper.Close()
+	})
+	return wrapper
+}
+
+// newLongTestRequest fits a proxy limited to one message once its first turn is dropped
+func newLongTestRequest() ChatCompletionRequest {
+	return ChatCompletionRequest{
+		Model: models.GPT4,
+		Messages: []message.Message{
+			{Role: role.User, Content: "hello"},
+			{Role: role.Assistant, Content: "hi"},
+			{Role: role.User, Content: "explain the result"},
+		},
+	}
+}
+
+func newTestRequest() ChatCompletionRequest {
+	return ChatCompletionRequest{
+		Model:    models.GPT4,
+		Messages: []message.Message{{Role: role.User, Content: "hello"}},
+	}
+}
+
+func TestInternalCallContextDeadline(t *testing.T) {
+	wrapper := newBufconnWrapper(t, &fakeProxyServer{block: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := wrapper.CallContext(ctx, newTestRequest())
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestInternalCallStreamOptions(t *testing.T) {
+	srv := &fakeProxyServer{chunks: []string{streamDone}}
+	wrapper := newBufconnWrapper(t, srv)
+
+	request := newTestRequest()
+	request.Temperature = completion.Ptr(0.0)
+	request.Seed = completion.Ptr(42)
+	_, err := wrapper.CallStream(context.Background(), request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.request.Temperature == nil || *srv.request.Temperature != 0 || *srv.request.Seed != 42 {
+		t.Fatalf("Expected the options in the proxied request, got %+v", srv.request.Options)
+	}
+}
+
+func TestInternalCallStream(t *testing.T) {
+	srv := &fakeProxyServer{chunks: []string{
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":" there"},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+		streamDone,
+	}}
+	wrapper := newBufconnWrapper(t, srv)
+
+	var deltas []string
+	response, err := wrapper.CallStream(context.Background(), newTestRequest(), func(chunk *ChatCompletionChunk) error {
+		for _, c := range chunk.Choices {
+			deltas = append(deltas, c.Delta.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !srv.request.Stream {
+		t.Fatal("Expected a streaming request")
+	}
+	if len(deltas) != 2 || deltas[0] != "Hello" {
+		t.Fatalf("Unexpected deltas %q", deltas)
+	}
+	if response.Choices[0].Message.Content != "Hello there" || response.Usage.TotalTokens != 7 {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+}
+
+func TestInternalCallStreamUnimplemented(t *testing.T) {
+	wrapper := newBufconnWrapper(t, &fakeProxyServer{})
+
+	chunks := 0
+	response, err := wrapper.CallStream(context.Background(), newTestRequest(), func(*ChatCompletionChunk) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks != 1 || response.Choices[0].Message.Content != "unary" {
+		t.Fatalf("Unexpected fallback response %+v", response)
+	}
+}
+
+func TestInternalCallRetry(t *testing.T) {
+	srv := &fakeProxyServer{failures: 2}
+	wrapper := newBufconnWrapperWithConfig(t, srv, Config{RetryPolicy: testPolicy})
+
+	response, err := wrapper.CallContext(context.Background(), newTestRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.failures != 0 || response.Choices[0].Message.Content != "unary" {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+}
+
+func TestInternalCallRetryAfterDrop(t *testing.T) {
+	srv := &fakeProxyServer{failures: 100, maxMessages: 1}
+	wrapper := newBufconnWrapperWithConfig(t, srv, Config{RetryPolicy: testPolicy})
+
+	_, err := wrapper.CallContext(context.Background(), newLongTestRequest())
+	if err == nil {
+		t.Fatal("Expected the overloaded error")
+	}
+	// every attempt sends the request and the request with the oldest messages dropped, without nested retries
+	if len(srv.received) != 2*testPolicy.MaxAttempts {
+		t.Fatalf("Expected %d requests, got %d", 2*testPolicy.MaxAttempts, len(srv.received))
+	}
+}
+
+func TestInternalCallMetaData(t *testing.T) {
+	srv := &fakeProxyServer{failures: 1}
+	wrapper := newBufconnWrapperWithConfig(t, srv, Config{RetryPolicy: testPolicy})
+
+	ctx := WithMetaData(context.Background(), ChatMetaData{TenantID: "tenant-1", Origin: "ide"})
+	_, err := wrapper.CallContext(ctx, newTestRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.received) != 2 {
+		t.Fatalf("Expected a retried call, got %d requests", len(srv.received))
+	}
+	first, retried := srv.received[0], srv.received[1]
+	if first.Tenant != "tenant-1" || first.Origin != "ide" || first.RequestId == "" {
+		t.Fatalf("Unexpected metadata %v", first)
+	}
+	if retried.RequestId != first.RequestId {
+		t.Fatalf("Retries should share the request id, got %q and %q", first.RequestId, retried.RequestId)
+	}
+}
+
+func TestInternalCallContentParts(t *testing.T) {
+	srv := &fakeProxyServer{}
+	wrapper := newBufconnWrapper(t, srv)
+
+	request := newTestRequest()
+	request.Messages[0].Parts = []message.ContentPart{message.ImageDataPart("image/png", []byte("png"), message.DetailLow)}
+	if _, err := wrapper.CallContext(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Messages []struct {
+			Content []message.ContentPart `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(srv.received[0].Content, &sent); err != nil {
+		t.Fatal(err)
+	}
+	parts := sent.Messages[0].Content
+	if len(parts) != 2 || parts[0].Text != "hello" || parts[1].ImageURL.URL != "data:image/png;base64,cG5n" {
+		t.Fatalf("Expected the content parts to be sent, got %+v", parts)
+	}
+}
@@ -1,11 +1,20 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/contentfilter"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"google.golang.org/grpc/credentials"
+	"net/http"
 	"net/url"
+	"time"
 )
 
 // const gptByAzure = "https://cxgpt4.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15"
@@ -20,59 +29,212 @@ type ChatMetaData struct {
 type ChatCompletionRequest struct {
 	Model    string            `json:"model"`
 	Messages []message.Message `json:"messages"`
+	completion.Options
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type Choice struct {
+	Index                int                    `json:"index,omitempty"`
+	Message              message.Message        `json:"message"`
+	FinishReason         string                 `json:"finish_reason,omitempty"`
+	ContentFilterResults *contentfilter.Results `json:"content_filter_results,omitempty"`
+}
+
+type Usage struct {
+	TotalTokens      int `json:"total_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
 }
 
 type ChatCompletionResponse struct {
-	ID      string `json:"id,omitempty"`
-	Choices []struct {
-		Index        int             `json:"index,omitempty"`
-		Message      message.Message `json:"message"`
-		FinishReason string          `json:"finish_reason,omitempty"`
-	} `json:"choices,omitempty"`
-	Usage struct {
-		TotalTokens      int `json:"total_tokens,omitempty"`
-		CompletionTokens int `json:"completion_tokens,omitempty"`
-		PromptTokens     int `json:"prompt_tokens,omitempty"`
-	} `json:"usage,omitempty"`
+	ID                  string                        `json:"id,omitempty"`
+	Model               string                        `json:"model,omitempty"`
+	Choices             []Choice                      `json:"choices,omitempty"`
+	Usage               Usage                         `json:"usage,omitempty"`
+	PromptFilterResults []contentfilter.PromptResults `json:"prompt_filter_results,omitempty"`
 }
 
+type ChunkChoice struct {
+	Index                int                    `json:"index"`
+	Delta                message.Delta          `json:"delta"`
+	FinishReason         string                 `json:"finish_reason,omitempty"`
+	ContentFilterResults *contentfilter.Results `json:"content_filter_results,omitempty"`
+}
+
+type ChatCompletionChunk struct {
+	ID                  string                        `json:"id,omitempty"`
+	Model               string                        `json:"model,omitempty"`
+	Choices             []ChunkChoice                 `json:"choices,omitempty"`
+	Usage               *Usage                        `json:"usage,omitempty"`
+	PromptFilterResults []contentfilter.PromptResults `json:"prompt_filter_results,omitempty"`
+}
+
+// ChunkHandler is called for every chunk received while streaming a completion
+type ChunkHandler func(chunk *ChatCompletionChunk) error
+
 type ErrorResponse struct {
 	Error struct {
 		Message string      `json:"message,omitempty"`
 		Type    string      `json:"type,omitempty"`
 		Param   string      `json:"param,omitempty"`
 		Code    interface{} `json:"code,omitempty"`
+		// set by Azure OpenAI when the prompt is rejected by the content filter
+		InnerError *struct {
+			Code                string                 `json:"code,omitempty"`
+			ContentFilterResult *contentfilter.Results `json:"content_filter_result,omitempty"`
+		} `json:"innererror,omitempty"`
 	} `json:"error,omitempty"`
 }
 
+// Config holds the settings shared by every backend
+type Config struct {
+	RetryPolicy retry.Policy
+	Azure       *AzureConfig
+	// Provider selects a registered backend by name instead of by the endpoint scheme
+	Provider string
+	// TLS secures the grpc proxy connection, which is plain text when nil
+	TLS *TLSConfig
+	// PerRPCCredentials are attached to every grpc call, e.g. a BearerToken
+	PerRPCCredentials credentials.PerRPCCredentials
+	// Model is the model of the calls, it names the Azure deployment when none is configured
+	Model string
+}
+
 type Wrapper interface {
 	Call(request ChatCompletionRequest) (*ChatCompletionResponse, error)
+	CallContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error)
+	CallStream(ctx context.Context, request ChatCompletionRequest, onChunk ChunkHandler) (*ChatCompletionResponse, error)
 	SetupCall(messages []message.Message)
 	Close() error
 }
 
-func NewWrapperFactory(endPoint, apiKey string, dropLen int) (Wrapper, error) {
+// NewWrapperFactory creates the backend of the provider named in the config,
+// or else of the endpoint scheme, endpoints with an unknown scheme are called over insecure grpc
+func NewWrapperFactory(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	if config.Provider != "" {
+		factory, ok := lookupProvider(config.Provider)
+		if !ok {
+			return nil, fmt.Errorf("unknown provider %q", config.Provider)
+		}
+		return factory(endPoint, apiKey, dropLen, config)
+	}
 	endPointURL, err := url.Parse(endPoint)
 	if err != nil {
 		return nil, err
 	}
-	if endPointURL.Scheme == "http" || endPointURL.Scheme == "https" {
-		return NewWrapperImpl(endPoint, apiKey, dropLen), nil
+	if factory, ok := lookupProvider(endPointURL.Scheme); ok {
+		return factory(endPoint, apiKey, dropLen, config)
 	}
-	return NewWrapperInternalImpl(endPoint, dropLen)
+	return NewWrapperInternalImpl(endPoint, dropLen, config)
+}
+
+// ResponseError is returned when the model answers with an error status
+type ResponseError struct {
+	StatusCode int
+	Code       interface{}
+	Type       string
+	Message    string
+	RetryAfter time.Duration
+	// ContentFilterResults is set when Azure OpenAI filtered the prompt
+	ContentFilterResults *contentfilter.Results
 }
 
-func fromResponse(statusCode int, e *ErrorResponse) error {
+func (e *ResponseError) Error() string {
 	var msg string
-	if e.Error.Message != "" {
-		msg = e.Error.Message
+	if e.Message != "" {
+		msg = e.Message
 	} else {
-		msg = fmt.Sprintf("%v", e.Error.Code)
+		msg = fmt.Sprintf("%v", e.Code)
 	}
 
-	msg = fmt.Sprintf("Error Code: %d, %s", statusCode, msg)
+	return fmt.Sprintf("Error Code: %d, %s", e.StatusCode, msg)
+}
+
+func fromResponse(statusCode int, e *ErrorResponse, retryAfter time.Duration) *ResponseError {
+	responseError := &ResponseError{
+		StatusCode: statusCode,
+		Code:       e.Error.Code,
+		Type:       e.Error.Type,
+		Message:    e.Error.Message,
+		RetryAfter: retryAfter,
+	}
+	if e.Error.InnerError != nil {
+		responseError.ContentFilterResults = e.Error.InnerError.ContentFilterResult
+	}
+	return responseError
+}
 
-	return errors.New(msg)
+// decodeErrorResponse decodes an error body, gateways in front of the model may answer with plain text or html
+func decodeErrorResponse(statusCode int, body []byte) *ErrorResponse {
+	var errorResponse = new(ErrorResponse)
+	err := json.Unmarshal(body, errorResponse)
+	if err != nil {
+		errorResponse.Error.Message = http.StatusText(statusCode)
+	}
+	return errorResponse
+}
+
+// withRetryAfter attaches the delay requested by the server to a transport error
+func withRetryAfter(err error, retryAfter time.Duration) error {
+	if retryAfter <= 0 {
+		return err
+	}
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		return err
+	}
+	return &transportError{err: err, retryAfter: retryAfter}
+}
+
+type transportError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+func withSetupMessages(setupMessages []message.Message, requestBody ChatCompletionRequest) ChatCompletionRequest {
+	if setupMessages == nil {
+		return requestBody
+	}
+	//true for GPT4
+	if requestBody.Model == models.GPT4 {
+		requestBody.Messages = append(setupMessages, requestBody.Messages...)
+	} else {
+		userIndex := findLastUserIndex(requestBody.Messages)
+		front := requestBody.Messages[:userIndex]
+		back := requestBody.Messages[userIndex:]
+		requestBody.Messages = append(front, setupMessages...)
+		requestBody.Messages = append(requestBody.Messages, back...)
+	}
+	return requestBody
+}
+
+// DropOldest removes up to count of the oldest turns before pinFrom, system messages are always kept
+func DropOldest(messages []message.Message, count, pinFrom int) ([]message.Message, bool) {
+	var kept []message.Message
+	dropped := 0
+	for start := 0; start < len(messages); {
+		end := message.TurnEnd(messages, start)
+		if dropped < count && end <= pinFrom && messages[start].Role != role.System {
+			dropped++
+		} else {
+			kept = append(kept, messages[start:end]...)
+		}
+		start = end
+	}
+	return kept, dropped > 0
 }
 
 func findLastUserIndex(messages []message.Message) int {
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+)
+
+func TestDropOldest(t *testing.T) {
+	messages := []message.Message{
+		{Role: role.System, Content: "setup"},
+		{Role: role.User, Content: "q1"},
+		{Role: role.Assistant, Content: "a1"},
+		{Role: role.User, Content: "q2"},
+	}
+	kept, ok := DropOldest(messages, 4, findLastUserIndex(messages))
+	if !ok || len(kept) != 2 || kept[0].Content != "setup" || kept[1].Content != "q2" {
+		t.Fatalf("Expected system and last user message to be kept, got %v", kept)
+	}
+	if _, ok = DropOldest(messages, 0, findLastUserIndex(messages)); ok {
+		t.Fatal("Nothing should be dropped when count is 0")
+	}
+	if _, ok = DropOldest(kept, 4, findLastUserIndex(kept)); ok {
+		t.Fatal("Pinned messages should not be dropped")
+	}
+}
+
+func TestDropOldestKeepsToolTurns(t *testing.T) {
+	messages := []message.Message{
+		{Role: role.User, Content: "q1"},
+		{Role: role.Assistant, ToolCalls: []message.ToolCall{{ID: "call_1", Type: "function"}}},
+		{Role: role.Tool, ToolCallID: "call_1", Content: "result"},
+		{Role: role.Assistant, Content: "a1"},
+		{Role: role.User, Content: "q2"},
+	}
+	kept, ok := DropOldest(messages, 2, findLastUserIndex(messages))
+	if !ok || len(kept) != 2 || kept[0].Content != "a1" {
+		t.Fatalf("Expected the tool call and its result to be dropped together, got %v", kept)
+	}
+}
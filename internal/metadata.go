@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type metaDataKey struct{}
+
+// WithMetaData returns a context carrying the metadata sent to the proxy with every request made with it
+func WithMetaData(ctx context.Context, metaData ChatMetaData) context.Context {
+	return context.WithValue(ctx, metaDataKey{}, metaData)
+}
+
+// MetaDataFromContext returns the metadata carried by the context, empty when there is none
+func MetaDataFromContext(ctx context.Context) ChatMetaData {
+	metaData, _ := ctx.Value(metaDataKey{}).(ChatMetaData)
+	return metaData
+}
+
+// EnsureRequestID returns a context whose metadata has a request id, retries of a call share the id
+func EnsureRequestID(ctx context.Context) context.Context {
+	metaData := MetaDataFromContext(ctx)
+	if metaData.RequestID != "" {
+		return ctx
+	}
+	metaData.RequestID = uuid.NewString()
+	return WithMetaData(ctx, metaData)
+}
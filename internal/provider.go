@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	ProviderOpenAI  = "openai"
+	ProviderAzure   = "azure"
+	ProviderGRPC    = "grpc"
+	ProviderGRPCTLS = "grpc+tls"
+	ProviderOllama  = "ollama"
+)
+
+const ollamaChatPath = "/v1/chat/completions"
+
+// ProviderFactory creates the backend of a provider, endPoint is the endpoint given to the wrapper
+type ProviderFactory func(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{
+		"http":          newHTTPWrapper,
+		"https":         newHTTPWrapper,
+		ProviderOpenAI:  newOpenAIWrapper,
+		ProviderAzure:   newAzureWrapper,
+		ProviderGRPC:    newGRPCWrapper,
+		ProviderGRPCTLS: newGRPCTLSWrapper,
+		ProviderOllama:  newOllamaWrapper,
+	}
+)
+
+// RegisterProvider makes a backend selectable by name or as the scheme of the endpoint,
+// registering an existing name replaces its factory
+func RegisterProvider(name string, factory ProviderFactory) {
+	if name == "" || factory == nil {
+		panic("provider name and factory are required")
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[strings.ToLower(name)] = factory
+}
+
+func lookupProvider(name string) (ProviderFactory, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	factory, ok := providers[strings.ToLower(name)]
+	return factory, ok
+}
+
+func newHTTPWrapper(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	if config.Azure != nil {
+		return NewAzureWrapperImpl(endPoint, apiKey, dropLen, config)
+	}
+	return NewWrapperImpl(endPoint, apiKey, dropLen, config), nil
+}
+
+func newOpenAIWrapper(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	httpEndPoint, err := withScheme(endPoint, "https")
+	if err != nil {
+		return nil, err
+	}
+	return NewWrapperImpl(httpEndPoint, apiKey, dropLen, config), nil
+}
+
+func newAzureWrapper(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	httpEndPoint, err := withScheme(endPoint, "https")
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureWrapperImpl(httpEndPoint, apiKey, dropLen, config)
+}
+
+func newGRPCWrapper(endPoint, _ string, dropLen int, config Config) (Wrapper, error) {
+	return NewWrapperInternalImpl(grpcTarget(endPoint), dropLen, config)
+}
+
+func newGRPCTLSWrapper(endPoint, _ string, dropLen int, config Config) (Wrapper, error) {
+	if config.TLS == nil {
+		config.TLS = &TLSConfig{}
+	}
+	return NewWrapperInternalImpl(grpcTarget(endPoint), dropLen, config)
+}
+
+// newOllamaWrapper calls the OpenAI compatible API of an Ollama server, which accepts any api key
+func newOllamaWrapper(endPoint, apiKey string, dropLen int, config Config) (Wrapper, error) {
+	httpEndPoint, err := withScheme(endPoint, "http")
+	if err != nil {
+		return nil, err
+	}
+	endPointURL, err := url.Parse(httpEndPoint)
+	if err != nil {
+		return nil, err
+	}
+	if endPointURL.Path == "" || endPointURL.Path == "/" {
+		endPointURL.Path = ollamaChatPath
+	}
+	return NewWrapperImpl(endPointURL.String(), apiKey, dropLen, config), nil
+}
+
+// withScheme replaces the provider scheme of the endpoint, an endpoint without one is left as is
+func withScheme(endPoint, scheme string) (string, error) {
+	endPointURL, err := url.Parse(endPoint)
+	if err != nil {
+		return "", err
+	}
+	if endPointURL.Scheme != "http" && endPointURL.Scheme != "https" {
+		endPointURL.Scheme = scheme
+	}
+	return endPointURL.String(), nil
+}
+
+// grpcTarget strips the provider scheme, leaving the host:port target understood by grpc
+func grpcTarget(endPoint string) string {
+	for _, scheme := range []string{ProviderGRPCTLS, ProviderGRPC} {
+		if target, ok := strings.CutPrefix(endPoint, scheme+"://"); ok {
+			return target
+		}
+	}
+	return endPoint
+}
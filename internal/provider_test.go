@@ -0,0 +1,33 @@
+package internal
+
+import "testing"
+
+func TestProviderEndPoints(t *testing.T) {
+	if target := grpcTarget("grpc+tls://proxy.example.com:443"); target != "proxy.example.com:443" {
+		t.Errorf("Unexpected grpc target %q", target)
+	}
+	if target := grpcTarget("grpc://localhost:50051"); target != "localhost:50051" {
+		t.Errorf("Unexpected grpc target %q", target)
+	}
+	endPoint, err := withScheme("azure://res.openai.azure.com", "https")
+	if err != nil || endPoint != "https://res.openai.azure.com" {
+		t.Errorf("Unexpected endpoint %q, err: %v", endPoint, err)
+	}
+	endPoint, err = withScheme("http://localhost:11434/v1/chat/completions", "https")
+	if err != nil || endPoint != "http://localhost:11434/v1/chat/completions" {
+		t.Errorf("Explicit http scheme should be kept, got %q, err: %v", endPoint, err)
+	}
+}
+
+func TestNewWrapperFactoryProviders(t *testing.T) {
+	for _, endPoint := range []string{"grpc://localhost:50051", "grpc+tls://localhost:443", "openai://api.openai.com/v1/chat/completions"} {
+		wrapper, err := NewWrapperFactory(endPoint, "key", 4, Config{})
+		if err != nil {
+			t.Fatalf("%s: %v", endPoint, err)
+		}
+		_ = wrapper.Close()
+	}
+	if _, err := NewWrapperFactory("https://example.com", "key", 4, Config{Provider: "missing"}); err == nil {
+		t.Fatal("Expected an unknown provider error")
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	headerRetryAfter              = "Retry-After"
+	headerRetryAfterMs            = "Retry-After-Ms"
+	headerRateLimitResetRequests  = "X-Ratelimit-Reset-Requests"
+	headerRateLimitResetTokens    = "X-Ratelimit-Reset-Tokens"
+	metadataRetryAfter            = "retry-after"
+	metadataRateLimitResetRequest = "x-ratelimit-reset-requests"
+	metadataRateLimitResetTokens  = "x-ratelimit-reset-tokens"
+)
+
+// permanentError stops the retry loop, e.g. when part of a stream was already delivered
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// withRetry runs call until it succeeds, fails with a non retryable error or the policy is exhausted
+func withRetry[T any](ctx context.Context, policy retry.Policy, call func() (T, error)) (T, error) {
+	var result T
+	var err error
+	for attempt := 1; ; attempt++ {
+		result, err = call()
+		if err == nil {
+			return result, nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return result, permanent.err
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return result, err
+		}
+		timer := time.NewTimer(retryDelay(policy, attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay waits as long as the server asked, up to the longest backoff of the policy, and backs off otherwise
+func retryDelay(policy retry.Policy, attempt int, err error) time.Duration {
+	hint := retryAfterHint(err)
+	if hint <= 0 {
+		return policy.Backoff(attempt)
+	}
+	if policy.MaxBackoff > 0 && hint > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return hint
+}
+
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		switch responseError.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted:
+			return true
+		}
+		return false
+	}
+	// transport errors, the request got no complete response. A url.Error is a net.Error itself, only the error
+	// it wraps tells a network failure from e.g. an invalid certificate or URL
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		err = urlError.Err
+	}
+	var netError net.Error
+	return errors.As(err, &netError) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryAfterHint(err error) time.Duration {
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		return responseError.RetryAfter
+	}
+	var transport *transportError
+	if errors.As(err, &transport) {
+		return transport.retryAfter
+	}
+	return 0
+}
+
+// retryAfterFromHeader reads the delay requested by the server, the rate limit reset headers only count when rate limited
+func retryAfterFromHeader(statusCode int, header http.Header) time.Duration {
+	// Azure OpenAI sends the delay in milliseconds along with the rounded Retry-After
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get(headerRetryAfterMs)), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if statusCode != http.StatusTooManyRequests {
+		return parseRetryAfter(header.Get(headerRetryAfter), "", "")
+	}
+	return parseRetryAfter(header.Get(headerRetryAfter), header.Get(headerRateLimitResetRequests), header.Get(headerRateLimitResetTokens))
+}
+
+func retryAfterFromMetadata(statusCode int, mds ...metadata.MD) time.Duration {
+	get := func(key string) string {
+		for _, md := range mds {
+			if values := md.Get(key); len(values) > 0 {
+				return values[0]
+			}
+		}
+		return ""
+	}
+	if statusCode != http.StatusTooManyRequests {
+		return parseRetryAfter(get(metadataRetryAfter), "", "")
+	}
+	return parseRetryAfter(get(metadataRetryAfter), get(metadataRateLimitResetRequest), get(metadataRateLimitResetTokens))
+}
+
+func parseRetryAfter(retryAfter, resetRequests, resetTokens string) time.Duration {
+	retryAfter = strings.TrimSpace(retryAfter)
+	if retryAfter != "" {
+		if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return time.Until(date)
+		}
+	}
+	var reset time.Duration
+	for _, value := range []string{resetRequests, resetTokens} {
+		if d, err := time.ParseDuration(strings.TrimSpace(value)); err == nil && d > reset {
+			reset = d
+		}
+	}
+	return reset
+}
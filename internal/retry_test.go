@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
+)
+
+var testPolicy = retry.Policy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.5,
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		retryAfter, resetRequests, resetTokens string
+		expected                               time.Duration
+	}{
+		{"2", "", "", 2 * time.Second},
+		{"", "1s", "6m0s", 6 * time.Minute},
+		{"", "120ms", "", 120 * time.Millisecond},
+		{"soon", "", "", 0},
+		{"", "", "", 0},
+	}
+	for _, c := range cases {
+		if actual := parseRetryAfter(c.retryAfter, c.resetRequests, c.resetTokens); actual != c.expected {
+			t.Errorf("parseRetryAfter(%q, %q, %q) = %v, expected %v", c.retryAfter, c.resetRequests, c.resetTokens, actual, c.expected)
+		}
+	}
+	header := http.Header{}
+	header.Set(headerRateLimitResetTokens, "3s")
+	if actual := retryAfterFromHeader(http.StatusInternalServerError, header); actual != 0 {
+		t.Errorf("Rate limit reset should only count when rate limited, got %v", actual)
+	}
+	if actual := retryAfterFromHeader(http.StatusTooManyRequests, header); actual != 3*time.Second {
+		t.Errorf("Expected 3s, got %v", actual)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	attempts := 0
+	result, err := withRetry(context.Background(), testPolicy, func() (int, error) {
+		attempts++
+		if attempts < 3 {
+			return 0, &ResponseError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return attempts, nil
+	})
+	if err != nil || result != 3 {
+		t.Fatalf("Expected success on third attempt, got %d, %v", result, err)
+	}
+
+	attempts = 0
+	_, err = withRetry(context.Background(), testPolicy, func() (int, error) {
+		attempts++
+		return 0, &ResponseError{StatusCode: http.StatusBadRequest}
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("Bad request should not be retried, attempts: %d", attempts)
+	}
+
+	attempts = 0
+	_, err = withRetry(context.Background(), testPolicy, func() (int, error) {
+		attempts++
+		return 0, &permanentError{&ResponseError{StatusCode: http.StatusBadGateway}}
+	})
+	if _, ok := err.(*ResponseError); !ok || attempts != 1 {
+		t.Fatalf("Permanent error should not be retried, attempts: %d, err: %v", attempts, err)
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	_, err := withRetry(ctx, testPolicy, func() (int, error) {
+		attempts++
+		cancel()
+		return 0, &ResponseError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("Cancelled call should not be replayed, attempts: %d", attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	cases := []struct {
+		retryAfter time.Duration
+		expected   time.Duration
+	}{
+		{0, time.Millisecond},
+		{200 * time.Millisecond, 200 * time.Millisecond},
+		// a longer hint than the backoff allows is waited as long as possible, not replaced by the backoff
+		{time.Minute, time.Second},
+	}
+	for _, c := range cases {
+		err := &ResponseError{StatusCode: http.StatusTooManyRequests, RetryAfter: c.retryAfter}
+		if actual := retryDelay(policy, 1, err); actual != c.expected {
+			t.Errorf("retryDelay with Retry-After %v = %v, expected %v", c.retryAfter, actual, c.expected)
+		}
+	}
+}
+
+func TestIsRetryableTransportErrors(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{&url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{&url.Error{Op: "Post", URL: "https://example.com", Err: io.ErrUnexpectedEOF}, true},
+		{&url.Error{Op: "Post", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, false},
+		{&url.Error{Op: "Post", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false},
+	}
+	for _, c := range cases {
+		if actual := isRetryable(c.err); actual != c.expected {
+			t.Errorf("isRetryable(%v) = %v, expected %v", c.err, actual, c.expected)
+		}
+	}
+}
+
+func TestCallRetryAfterDrop(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var request ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if len(request.Messages) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"maximum context length","code":"context_length_exceeded"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":{"message":"The engine is currently overloaded","type":"server_error"}}`))
+	}))
+	defer server.Close()
+
+	wrapper := NewWrapperImpl(server.URL, "key", 4, Config{RetryPolicy: testPolicy})
+	_, err := wrapper.CallContext(context.Background(), newLongTestRequest())
+	if err == nil {
+		t.Fatal("Expected the overloaded error")
+	}
+	// every attempt sends the request and the request with the oldest messages dropped, without nested retries
+	if requests != 2*testPolicy.MaxAttempts {
+		t.Fatalf("Expected %d requests, got %d", 2*testPolicy.MaxAttempts, requests)
+	}
+}
@@ -0,0 +1,128 @@
+package secrets
+
+// ahoCorasick finds every occurrence of a set of keywords in a single pass over the text,
+// keywords are lower case and matched ignoring ASCII case
+type ahoCorasick struct {
+	next    [][256]int32
+	outputs [][]int
+}
+
+func newAhoCorasick(keywords []string) *ahoCorasick {
+	a := &ahoCorasick{}
+	a.addNode()
+	for id, keyword := range keywords {
+		state := int32(0)
+		for i := 0; i < len(keyword); i++ {
+			c := keyword[i]
+			if a.next[state][c] == 0 {
+				a.next[state][c] = a.addNode()
+			}
+			state = a.next[state][c]
+		}
+		a.outputs[state] = append(a.outputs[state], id)
+	}
+
+	// turn the trie into a automaton, missing transitions follow the failure links
+	fail := make([]int32, len(a.next))
+	var queue []int32
+	for c := 0; c < 256; c++ {
+		if child := a.next[0][c]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		a.outputs[state] = append(a.outputs[state], a.outputs[fail[state]]...)
+		for c := 0; c < 256; c++ {
+			child := a.next[state][c]
+			if child == 0 {
+				a.next[state][c] = a.next[fail[state]][c]
+				continue
+			}
+			fail[child] = a.next[fail[state]][c]
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+func (a *ahoCorasick) addNode() int32 {
+	a.next = append(a.next, [256]int32{})
+	a.outputs = append(a.outputs, nil)
+	return int32(len(a.next) - 1)
+}
+
+// match calls onMatch with the id of every keyword occurrence in the text
+func (a *ahoCorasick) match(text string, onMatch func(keyword int)) {
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		state = a.next[state][c]
+		for _, id := range a.outputs[state] {
+			onMatch(id)
+		}
+	}
+}
+
+// prefilter selects the lines each rule may match, by the keywords of the rules
+type prefilter struct {
+	matcher      *ahoCorasick
+	keywordRules [][]int
+	hasKeywords  []bool
+}
+
+func newPrefilter(regexes []SecretRegex) *prefilter {
+	p := &prefilter{hasKeywords: make([]bool, len(regexes))}
+	keywordIDs := map[string]int{}
+	var keywords []string
+	for rule, re := range regexes {
+		for _, keyword := range re.Keywords {
+			if keyword == "" {
+				continue
+			}
+			id, ok := keywordIDs[keyword]
+			if !ok {
+				id = len(keywords)
+				keywordIDs[keyword] = id
+				keywords = append(keywords, keyword)
+				p.keywordRules = append(p.keywordRules, nil)
+			}
+			p.keywordRules[id] = append(p.keywordRules[id], rule)
+			p.hasKeywords[rule] = true
+		}
+	}
+	if len(keywords) > 0 {
+		p.matcher = newAhoCorasick(keywords)
+	}
+	return p
+}
+
+// candidates returns the indexes of the lines containing a keyword of each rule, nil for rules without keywords
+func (p *prefilter) candidates(lines []string) [][]int {
+	candidates := make([][]int, len(p.hasKeywords))
+	lastLine := make([]int, len(p.hasKeywords))
+	for rule, hasKeywords := range p.hasKeywords {
+		if hasKeywords {
+			candidates[rule] = []int{}
+		}
+		lastLine[rule] = -1
+	}
+	if p.matcher == nil {
+		return candidates
+	}
+	for index, line := range lines {
+		p.matcher.match(line, func(keyword int) {
+			for _, rule := range p.keywordRules[keyword] {
+				if lastLine[rule] != index {
+					lastLine[rule] = index
+					candidates[rule] = append(candidates[rule], index)
+				}
+			}
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,142 @@
+package secrets
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+const DefaultSeverity = "HIGH"
+
+// edit is a replacement of oldLen bytes at start by newLen bytes, in the coordinates of the text it was applied to
+type edit struct {
+	start, oldLen, newLen int
+}
+
+// unapply maps a position of the text after the edits back to the text before them. A position inside a
+// replacement maps to the start of the replaced text, or to its end for the end of a range
+func unapply(edits []edit, pos int, end bool) int {
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		switch {
+		case pos <= e.start:
+		case pos >= e.start+e.newLen:
+			pos += e.oldLen - e.newLen
+		case end:
+			pos = e.start + e.oldLen
+		default:
+			pos = e.start
+		}
+	}
+	return pos
+}
+
+// lineStarts returns the offset of every line of the text
+func lineStarts(text string) []int {
+	starts := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// position returns the 1-based line and column of an offset
+func position(starts []int, offset int) (line, column int) {
+	index := sort.Search(len(starts), func(i int) bool { return starts[i] > offset }) - 1
+	return index + 1, offset - starts[index] + 1
+}
+
+// findingTracker maps the ranges masked by replaceMatches back to the original content. The single line rules
+// edit the lines of the content, then the multiline rules edit the joined lines
+type findingTracker struct {
+	starts     []int
+	lineEdits  [][]edit
+	joined     []int
+	edits      []edit
+	detections []detection
+}
+
+// detection is a finding with the start of the whole match, which includes the context kept in clear
+type detection struct {
+	maskedSecret.Finding
+	matchStart int
+}
+
+func newFindingTracker(content string, lines int) *findingTracker {
+	return &findingTracker{
+		starts:    lineStarts(content),
+		lineEdits: make([][]edit, lines),
+	}
+}
+
+// lineOffset maps a column of a line, after the edits so far, to an offset of the original content
+func (t *findingTracker) lineOffset(line, column int, end bool) int {
+	return t.starts[line] + unapply(t.lineEdits[line], column, end)
+}
+
+// joinedOffset maps an offset of the joined lines, after the edits so far, to an offset of the original content
+func (t *findingTracker) joinedOffset(offset int, end bool) int {
+	offset = unapply(t.edits, offset, end)
+	line := sort.Search(len(t.joined), func(i int) bool { return t.joined[i] > offset }) - 1
+	return t.lineOffset(line, offset-t.joined[line], end)
+}
+
+// join starts the multiline rules on the joined lines
+func (t *findingTracker) join(lines []string) {
+	t.joined = lineStarts(strings.Join(lines, "\n"))
+}
+
+func (t *findingTracker) add(re SecretRegex, matchStart, start, end int, secret, replacement string) {
+	finding := maskedSecret.Finding{
+		RuleID:      re.ID,
+		RuleName:    re.QueryName,
+		Severity:    re.severity(),
+		Category:    re.category(),
+		StartOffset: start,
+		EndOffset:   end,
+		Entropy:     secretEntropy(secret),
+		Replacement: replacement,
+	}
+	finding.StartLine, finding.StartColumn = position(t.starts, start)
+	finding.EndLine, finding.EndColumn = position(t.starts, end)
+	t.detections = append(t.detections, detection{Finding: finding, matchStart: matchStart})
+}
+
+// sorted returns the detections in the order of the content
+func (t *findingTracker) sorted() []detection {
+	sort.SliceStable(t.detections, func(i, j int) bool {
+		return t.detections[i].StartOffset < t.detections[j].StartOffset
+	})
+	return t.detections
+}
+
+func findings(detections []detection) []maskedSecret.Finding {
+	var findings []maskedSecret.Finding
+	for _, detection := range detections {
+		findings = append(findings, detection.Finding)
+	}
+	return findings
+}
+
+// secretEntropy is the highest of the base64 and hex entropies of the secret
+func secretEntropy(secret string) float64 {
+	return math.Max(calculateEntropy(secret, Base64Chars), calculateEntropy(secret, HexChars))
+}
+
+func (re SecretRegex) category() string {
+	if re.Category == "" {
+		return CategorySecret
+	}
+	return re.Category
+}
+
+func (re SecretRegex) severity() string {
+	if re.Severity == "" {
+		return DefaultSeverity
+	}
+	return re.Severity
+}
@@ -0,0 +1,198 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// gitleaksMaxEntropy is the upper bound of the entropy interval of imported rules, gitleaks only sets a minimum
+const gitleaksMaxEntropy = 8
+
+type gitleaksConfig struct {
+	Title  string `toml:"title"`
+	Extend struct {
+		Path          string   `toml:"path"`
+		URL           string   `toml:"url"`
+		UseDefault    bool     `toml:"useDefault"`
+		DisabledRules []string `toml:"disabledRules"`
+	} `toml:"extend"`
+	Rules      []gitleaksRule      `toml:"rules"`
+	Allowlist  *gitleaksAllowlist  `toml:"allowlist"`
+	Allowlists []gitleaksAllowlist `toml:"allowlists"`
+}
+
+type gitleaksRule struct {
+	ID          string              `toml:"id"`
+	Description string              `toml:"description"`
+	Regex       string              `toml:"regex"`
+	SecretGroup int                 `toml:"secretGroup"`
+	Entropy     float64             `toml:"entropy"`
+	Path        string              `toml:"path"`
+	Keywords    []string            `toml:"keywords"`
+	Allowlist   *gitleaksAllowlist  `toml:"allowlist"`
+	Allowlists  []gitleaksAllowlist `toml:"allowlists"`
+	Required    []interface{}       `toml:"required"`
+}
+
+type gitleaksAllowlist struct {
+	Description string   `toml:"description"`
+	Condition   string   `toml:"condition"`
+	Regexes     []string `toml:"regexes"`
+	RegexTarget string   `toml:"regexTarget"`
+	StopWords   []string `toml:"stopwords"`
+	Paths       []string `toml:"paths"`
+	Commits     []string `toml:"commits"`
+	TargetRules []string `toml:"targetRules"`
+}
+
+// ImportWarning is a gitleaks construct that was dropped or imported with a different meaning
+type ImportWarning struct {
+	RuleID  string
+	Message string
+}
+
+func (w ImportWarning) String() string {
+	if w.RuleID == "" {
+		return w.Message
+	}
+	return fmt.Sprintf("rule %s: %s", w.RuleID, w.Message)
+}
+
+// ImportGitleaks converts a gitleaks TOML configuration into a rule pack.
+// Allowlist regexes are matched against their regexTarget and stopwords against the secret, as gitleaks does.
+// Path and commit allowlists have no meaning for masked content and are dropped, each such difference is
+// reported as a warning
+func ImportGitleaks(data []byte) (*RulePack, []ImportWarning, error) {
+	var config gitleaksConfig
+	if err := toml.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("parse gitleaks config: %w", err)
+	}
+
+	importer := &gitleaksImporter{pack: &RulePack{DisabledRules: config.Extend.DisabledRules}}
+	if config.Extend.UseDefault {
+		importer.warn("", "extend.useDefault: the gitleaks default rules are not available, the embedded rules are used instead")
+	}
+	if config.Extend.Path != "" || config.Extend.URL != "" {
+		importer.warn("", "extend.path and extend.url are not supported, import the extended configuration separately")
+	}
+
+	ruleIndex := map[string]int{}
+	for _, rule := range config.Rules {
+		if secretRule, ok := importer.rule(rule); ok {
+			ruleIndex[secretRule.ID] = len(importer.pack.Rules)
+			importer.pack.Rules = append(importer.pack.Rules, secretRule)
+		}
+	}
+
+	globals := config.Allowlists
+	if config.Allowlist != nil {
+		globals = append([]gitleaksAllowlist{*config.Allowlist}, globals...)
+	}
+	for _, allowlist := range globals {
+		allowRules := importer.allowRules("", allowlist)
+		if len(allowlist.TargetRules) == 0 {
+			importer.pack.AllowRules = append(importer.pack.AllowRules, allowRules...)
+			continue
+		}
+		for _, id := range allowlist.TargetRules {
+			index, ok := ruleIndex[id]
+			if !ok {
+				importer.warn("", fmt.Sprintf("allowlist %q targets unknown rule %q", allowlist.Description, id))
+				continue
+			}
+			importer.pack.Rules[index].AllowRules = append(importer.pack.Rules[index].AllowRules, allowRules...)
+		}
+	}
+
+	return importer.pack, importer.warnings, nil
+}
+
+// LoadGitleaks reads and converts a gitleaks TOML configuration, such as .gitleaks.toml
+func LoadGitleaks(path string) (*RulePack, []ImportWarning, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ImportGitleaks(data)
+}
+
+type gitleaksImporter struct {
+	pack     *RulePack
+	warnings []ImportWarning
+}
+
+func (i *gitleaksImporter) warn(ruleID, message string) {
+	i.warnings = append(i.warnings, ImportWarning{RuleID: ruleID, Message: message})
+}
+
+func (i *gitleaksImporter) rule(rule gitleaksRule) (SecretRule, bool) {
+	if rule.Regex == "" {
+		i.warn(rule.ID, "rules matching only a path are not supported, the rule is skipped")
+		return SecretRule{}, false
+	}
+	name := rule.Description
+	if name == "" {
+		name = rule.ID
+	}
+	secretRule := SecretRule{
+		ID:          rule.ID,
+		Name:        name,
+		Regex:       rule.Regex,
+		Keywords:    rule.Keywords,
+		SecretGroup: rule.SecretGroup,
+	}
+	if rule.Entropy > 0 {
+		secretRule.Entropies = []Entropy{{Group: rule.SecretGroup, Min: rule.Entropy, Max: gitleaksMaxEntropy}}
+	}
+	if rule.Path != "" {
+		i.warn(rule.ID, "path is not supported, the rule applies to all content")
+	}
+	if len(rule.Required) > 0 {
+		i.warn(rule.ID, "required rules are not supported, the rule matches on its own")
+	}
+	allowlists := rule.Allowlists
+	if rule.Allowlist != nil {
+		allowlists = append([]gitleaksAllowlist{*rule.Allowlist}, allowlists...)
+	}
+	for _, allowlist := range allowlists {
+		secretRule.AllowRules = append(secretRule.AllowRules, i.allowRules(rule.ID, allowlist)...)
+	}
+	return secretRule, true
+}
+
+func (i *gitleaksImporter) allowRules(ruleID string, allowlist gitleaksAllowlist) []AllowRule {
+	if len(allowlist.Paths) > 0 || len(allowlist.Commits) > 0 {
+		if strings.EqualFold(allowlist.Condition, "AND") {
+			i.warn(ruleID, fmt.Sprintf("allowlist %q requires paths or commits, which are not supported, the allowlist is skipped", allowlist.Description))
+			return nil
+		}
+		i.warn(ruleID, fmt.Sprintf("allowlist %q: paths and commits are not supported and are dropped", allowlist.Description))
+	}
+	// the allow rules are alternatives, a match allowed only by a regex and a stopword together stays masked
+	if strings.EqualFold(allowlist.Condition, "AND") && len(allowlist.Regexes) > 0 && len(allowlist.StopWords) > 0 {
+		i.warn(ruleID, fmt.Sprintf("allowlist %q requires both a regex and a stopword, which is not supported, the allowlist is skipped", allowlist.Description))
+		return nil
+	}
+	target := allowlist.RegexTarget
+	switch target {
+	case "":
+		target = AllowTargetSecret
+	case AllowTargetSecret, AllowTargetMatch, AllowTargetLine:
+	default:
+		i.warn(ruleID, fmt.Sprintf("allowlist %q: unknown regexTarget %q, the allowlist is skipped", allowlist.Description, target))
+		return nil
+	}
+
+	var allowRules []AllowRule
+	for _, regex := range allowlist.Regexes {
+		allowRules = append(allowRules, AllowRule{Description: allowlist.Description, Regex: regex, Target: target})
+	}
+	for _, stopWord := range allowlist.StopWords {
+		allowRules = append(allowRules, AllowRule{Description: allowlist.Description, Regex: "(?i)" + regexp.QuoteMeta(stopWord), Target: AllowTargetSecret})
+	}
+	return allowRules
+}
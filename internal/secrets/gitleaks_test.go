@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+const gitleaksConfigExample = `
+title = "company gitleaks config"
+
+[extend]
+useDefault = true
+disabledRules = ["generic-api-key"]
+
+[[rules]]
+id = "acme-token"
+description = "ACME Token"
+regex = '''acme_[0-9a-f]{32}'''
+keywords = ["acme_"]
+entropy = 3.0
+
+[rules.allowlist]
+description = "ACME test tokens"
+regexTarget = "line"
+regexes = ['''acme_test''']
+stopwords = ["deadbeefdeadbeefdeadbeefdeadbeef"]
+
+[[rules]]
+id = "acme-files"
+path = '''\.acme$'''
+
+[[rules]]
+id = "internal-password"
+description = "Internal Password"
+regex = '''int_pwd=(\S+)'''
+secretGroup = 1
+path = '''\.env$'''
+
+[[rules.allowlists]]
+description = "local passwords"
+condition = "AND"
+regexes = ['''^local''']
+stopwords = ["dev"]
+
+[allowlist]
+description = "global"
+paths = ['''vendor/''']
+regexes = ['''EXAMPLE''']
+`
+
+func TestImportGitleaks(t *testing.T) {
+	pack, warnings, err := ImportGitleaks([]byte(gitleaksConfigExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack.Rules) != 2 || pack.Rules[0].ID != "acme-token" || pack.Rules[1].Name != "Internal Password" {
+		t.Fatalf("Unexpected rules %+v", pack.Rules)
+	}
+	acme := pack.Rules[0]
+	if len(acme.Keywords) != 1 || len(acme.Entropies) != 1 || acme.Entropies[0].Min != 3.0 || len(acme.AllowRules) != 2 {
+		t.Fatalf("Unexpected ACME rule %+v", acme)
+	}
+	if acme.AllowRules[0].Target != AllowTargetLine || acme.AllowRules[1].Target != AllowTargetSecret || len(pack.Rules[1].AllowRules) != 0 {
+		t.Fatalf("Unexpected allow rules %+v, %+v", acme.AllowRules, pack.Rules[1].AllowRules)
+	}
+	if len(pack.AllowRules) != 1 || pack.AllowRules[0].Regex != "EXAMPLE" || pack.AllowRules[0].Target != AllowTargetSecret || len(pack.DisabledRules) != 1 {
+		t.Fatalf("Unexpected global allow rules %+v", pack)
+	}
+
+	expected := []string{"extend.useDefault", "acme-files: rules matching only a path", "internal-password: path is not supported",
+		"internal-password: allowlist \"local passwords\" requires both a regex and a stopword", "allowlist \"global\": paths and commits"}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %v", len(expected), warnings)
+	}
+	for i, warning := range warnings {
+		if !strings.Contains(warning.String(), expected[i]) {
+			t.Errorf("Warning %d: expected %q, got %q", i, expected[i], warning.String())
+		}
+	}
+
+	ruleSet, err := NewRuleSet(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "token acme_0123456789abcdef0123456789abcdef\nint_pwd=s3cr3t\nacme_test acme_0123456789abcdef0123456789abcdef\n" +
+		"deadbeefdeadbeefdeadbeefdeadbeef acme_0123456789abcdef0123456789abcdef\nEXAMPLE int_pwd=s3cr3t int_pwd=EXAMPLE1\nint_pwd=localdev"
+	masked, _, err := ruleSet.Mask(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the secret group of the internal password is masked, the stopwords and the global regex allow only the
+	// secrets containing them, the line regex the whole line, the skipped AND allowlist allows nothing
+	expectedMasked := "token <masked>\nint_pwd=<masked>\nacme_test acme_0123456789abcdef0123456789abcdef\n" +
+		"deadbeefdeadbeefdeadbeefdeadbeef <masked>\nEXAMPLE int_pwd=<masked> int_pwd=EXAMPLE1\nint_pwd=<masked>"
+	if masked != expectedMasked {
+		t.Fatalf("Expected %q, got %q", expectedMasked, masked)
+	}
+}
+
+const gitleaksEntropyExample = `
+[[rules]]
+id = "acme-token"
+regex = '''acme_[0-9a-f]{32}'''
+entropy = 3.0
+
+[[rules]]
+id = "internal-password"
+regex = '''int_pwd=(\S+)'''
+secretGroup = 1
+entropy = 2.5
+`
+
+func TestImportGitleaksEntropy(t *testing.T) {
+	pack, _, err := ImportGitleaks([]byte(gitleaksEntropyExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleSet, err := NewRuleSet(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the entropy is measured on each match, or on its secret group, low entropy matches are kept
+	content := "acme_00000000000000000000000000000000 acme_0123456789abcdef0123456789abcdef\n" +
+		"int_pwd=aaaaaaaaaaaa int_pwd=Xk9#mQ2!vL7p"
+	scanner, err := ruleSet.Scanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := scanner.Scan(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "acme_00000000000000000000000000000000 <masked>\nint_pwd=aaaaaaaaaaaa int_pwd=<masked>"
+	if entry.MaskedFile != expected {
+		t.Fatalf("Expected %q, got %q", expected, entry.MaskedFile)
+	}
+	if len(entry.Findings) != 2 || entry.Findings[1].StartLine != 2 || entry.Findings[1].StartColumn != 30 {
+		t.Fatalf("Unexpected findings %+v", entry.Findings)
+	}
+
+	pack.Rules[1].SecretGroup = 2
+	if _, err := NewRuleSet(pack); err == nil || !strings.Contains(err.Error(), "secretGroup") {
+		t.Fatalf("Expected a secretGroup validation error, got %v", err)
+	}
+}
+
+func TestImportGitleaksInvalid(t *testing.T) {
+	if _, _, err := ImportGitleaks([]byte("[[rules]\nid = ")); err == nil {
+		t.Fatal("Expected a parse error")
+	}
+}
@@ -3,7 +3,6 @@ package secrets
 import (
 	_ "embed"
 	"encoding/json"
-	"fmt"
 	"math"
 	"regexp"
 	"strings"
@@ -29,9 +28,18 @@ type Multiline struct {
 	DetectLineGroup int `json:"detectLineGroup"`
 }
 
+// Targets of an allow rule, the part of a match its regex is matched against
+const (
+	AllowTargetLine   = "line"
+	AllowTargetMatch  = "match"
+	AllowTargetSecret = "secret"
+)
+
 type AllowRule struct {
 	Description string `json:"description"`
 	Regex       string `json:"regex"`
+	// Target is AllowTargetLine when empty, the lines of a multiline match
+	Target string `json:"target,omitempty"`
 }
 
 type SecretRule struct {
@@ -42,6 +50,12 @@ type SecretRule struct {
 	Multiline   Multiline   `json:"multiline"`
 	AllowRules  []AllowRule `json:"allowRules"`
 	SpecialMask string      `json:"specialMask"`
+	// Keywords skip the rule for content containing none of them, case insensitive
+	Keywords []string `json:"keywords,omitempty"`
+	// Severity of the findings of the rule, DefaultSeverity when empty
+	Severity string `json:"severity,omitempty"`
+	// SecretGroup masks only this group of the match, the whole match when 0
+	SecretGroup int `json:"secretGroup,omitempty"`
 }
 
 type SecretRules struct {
@@ -50,12 +64,24 @@ type SecretRules struct {
 }
 
 type SecretRegex struct {
-	QueryName   string
-	Regex       *regexp.Regexp
-	Multiline   Multiline
-	Entropies   []Entropy
-	AllowRules  []*regexp.Regexp
-	SpecialMask *regexp.Regexp
+	ID         string
+	QueryName  string
+	Regex      *regexp.Regexp
+	Multiline  Multiline
+	Entropies  []Entropy
+	AllowRules []*regexp.Regexp
+	// MatchAllowRules and SecretAllowRules are matched against the whole match and against the secret
+	MatchAllowRules  []*regexp.Regexp
+	SecretAllowRules []*regexp.Regexp
+	SpecialMask      *regexp.Regexp
+	Keywords         []string
+	Severity         string
+	// Category of the findings, CategorySecret when empty, other categories get their own placeholders
+	Category string
+	// Validate rejects the matches failing a check the regex cannot express, such as a checksum
+	Validate func(string) bool
+	// SecretGroup masks only this group of the match, the whole match when 0
+	SecretGroup int
 }
 
 type Result struct {
@@ -97,12 +123,18 @@ func LoadRegexps() ([]SecretRegex, []*regexp.Regexp, error) {
 		regexCompiled, _ := regexp.Compile(regex)
 
 		secretRegex := &SecretRegex{
+			ID:          regexStruct.ID,
 			QueryName:   regexStruct.Name,
 			Regex:       regexCompiled,
 			AllowRules:  allowRules,
 			Multiline:   regexStruct.Multiline,
 			Entropies:   regexStruct.Entropies,
 			SpecialMask: specialMaskCompiled,
+			Severity:    strings.ToUpper(regexStruct.Severity),
+			SecretGroup: regexStruct.SecretGroup,
+		}
+		for _, keyword := range regexStruct.Keywords {
+			secretRegex.Keywords = append(secretRegex.Keywords, strings.ToLower(keyword))
 		}
 		regexes = append(regexes, *secretRegex)
 	}
@@ -181,55 +213,98 @@ func calculateEntropy(token, charSet string) float64 {
 
 // ReplaceMatches If matches between the regex and the file content, then replace the match with the string "<masked>"
 func ReplaceMatches(fileName string, result string, regexs []SecretRegex, allowRegexes []*regexp.Regexp) (string, []Result, []maskedSecret.MaskedSecret) {
+	masked, results, maskedSecrets, _ := replaceMatches(fileName, result, regexs, allowRegexes, newPrefilter(regexs), nil)
+	return masked, results, maskedSecrets
+}
+
+// replaceMatches masks the matches with the placeholders of the masker, or with "<masked>" when it is nil, and
+// reports where each masked secret is in the content. Rules with keywords only run on the lines the prefilter
+// selects for them
+func replaceMatches(fileName string, result string, regexs []SecretRegex, allowRegexes []*regexp.Regexp,
+	filter *prefilter, masker *Masker) (string, []Result, []maskedSecret.MaskedSecret, []detection) {
 	var results []Result
 	var maskedSecrets []maskedSecret.MaskedSecret
 	var multilineRegexes []SecretRegex
 
 	lines := strings.Split(strings.ReplaceAll(result, "\r\n", "\n"), "\n")
+	tracker := newFindingTracker(result, len(lines))
+	candidates := filter.candidates(lines)
 	// Replace matches
-	for _, re := range regexs {
+	for i, re := range regexs {
+		ruleLines := candidates[i]
 		if re.Multiline.DetectLineGroup != 0 {
-			multilineRegexes = append(multilineRegexes, re)
+			if ruleLines == nil || len(ruleLines) > 0 {
+				multilineRegexes = append(multilineRegexes, re)
+			}
 			continue
 		}
-		for index, line := range lines {
+		if ruleLines == nil {
+			ruleLines = make([]int, len(lines))
+			for index := range lines {
+				ruleLines[index] = index
+			}
+		}
 
-			originalLine := lines[index]
-			lines[index] = re.Regex.ReplaceAllStringFunc(line, func(match string) string {
-				for _, allowRule := range append(re.AllowRules, allowRegexes...) {
-					if allowRule.FindString(line) != "" {
-						return match
-					}
+		for _, index := range ruleLines {
+			line := lines[index]
+			var masked strings.Builder
+			var edits []edit
+			last := 0
+		matches:
+			for _, loc := range re.Regex.FindAllStringSubmatchIndex(line, -1) {
+				match := line[loc[0]:loc[1]]
+				if allowed(line, re.AllowRules, allowRegexes) || allowed(match, re.MatchAllowRules) {
+					continue matches
+				}
+				if re.Validate != nil && !re.Validate(match) {
+					continue
 				}
-
-				groups := re.Regex.FindAllStringSubmatch(result, -1)
 				for _, entropy := range re.Entropies {
-					if len(groups) < entropy.Group {
-						if ok, _ := CheckEntropyInterval(entropy, groups[0][entropy.Group]); !ok {
-							return match
-						}
+					if ok, _ := CheckEntropyInterval(entropy, submatch(line, loc, entropy.Group)); !ok {
+						continue matches
 					}
 				}
 
+				// the secret is the whole match, or its secret group, whose start is then not masked by the special mask
+				secretStart, secretEnd := loc[0], loc[1]
 				startOfMatch := ""
-				if re.SpecialMask != nil {
+				if group := re.SecretGroup; group > 0 && 2*group+1 < len(loc) && loc[2*group] >= 0 {
+					secretStart, secretEnd = loc[2*group], loc[2*group+1]
+				} else if re.SpecialMask != nil {
 					startOfMatch = re.SpecialMask.FindString(line)
 				}
-				maskedSecret := fmt.Sprintf("%s<masked>", startOfMatch)
-				results = append(results, Result{QueryName: "Passwords And Secrets - " + re.QueryName, Line: index + 1, FileName: fileName, Severity: "HIGH"})
-				return maskedSecret
-			})
-			if originalLine != lines[index] {
-				// Add the masked string to return
-				maskedSecretElement := maskedSecret.MaskedSecret{}
-				maskedSecretElement.Secret = originalLine
-				maskedSecretElement.Masked = lines[index]
-				maskedSecretElement.Line = index
-				maskedSecrets = append(maskedSecrets, maskedSecretElement)
+				secret := line[secretStart:secretEnd]
+				if allowed(secret, re.SecretAllowRules) {
+					continue
+				}
+				maskedSecret := masker.mask(re, startOfMatch, secret)
+				results = append(results, Result{QueryName: "Passwords And Secrets - " + re.QueryName, Line: index + 1, FileName: fileName, Severity: re.severity()})
+
+				kept := keptPrefix(secret, startOfMatch, maskedSecret)
+				start, end := secretStart+kept, secretEnd
+				tracker.add(re, tracker.lineOffset(index, loc[0], false), tracker.lineOffset(index, start, false), tracker.lineOffset(index, end, true), line[start:end], maskedSecret[kept:])
+				masked.WriteString(line[last:secretStart])
+				edits = append(edits, edit{start: masked.Len() + kept, oldLen: end - start, newLen: len(maskedSecret) - kept})
+				masked.WriteString(maskedSecret)
+				last = secretEnd
+			}
+			if len(edits) == 0 {
+				continue
 			}
+			masked.WriteString(line[last:])
+			lines[index] = masked.String()
+			tracker.lineEdits[index] = append(tracker.lineEdits[index], edits...)
+
+			// Add the masked string to return
+			maskedSecretElement := maskedSecret.MaskedSecret{}
+			maskedSecretElement.Secret = line
+			maskedSecretElement.Masked = lines[index]
+			maskedSecretElement.Line = index
+			maskedSecrets = append(maskedSecrets, maskedSecretElement)
 		}
 	}
 	result = strings.Join(lines[:], "\n")
+	tracker.join(lines)
 	for _, re := range multilineRegexes {
 		// Find all matches of the regular expression in the string
 		groups := re.Regex.FindStringSubmatchIndex(result)
@@ -240,15 +315,8 @@ func ReplaceMatches(fileName string, result string, regexs []SecretRegex, allowR
 			firstLine := getLineNumber(result, groups[0])
 			lastLine := getLineNumber(result, groups[1])
 			fullContext := getLines(result, firstLine, lastLine)
-			allowed := false
 
-			for _, allowRule := range append(re.AllowRules, allowRegexes...) {
-				if allowRule.FindString(fullContext) != "" {
-					allowed = true
-					break
-				}
-			}
-			if allowed { // Allowed by the allowRules of the regex
+			if allowed(fullContext, re.AllowRules, allowRegexes) { // Allowed by the allowRules of the regex
 				groups = nil
 				continue
 			}
@@ -262,6 +330,10 @@ func ReplaceMatches(fileName string, result string, regexs []SecretRegex, allowR
 			}
 
 			stringToMask := result[groups[re.Multiline.DetectLineGroup*2]:groups[re.Multiline.DetectLineGroup*2+1]]
+			if allowed(matchString, re.MatchAllowRules) || allowed(stringToMask, re.SecretAllowRules) {
+				groups = nil
+				continue
+			}
 			lineOfSecret := getLineNumber(result, groups[re.Multiline.DetectLineGroup*2])
 
 			startOfMatch := ""
@@ -272,9 +344,9 @@ func ReplaceMatches(fileName string, result string, regexs []SecretRegex, allowR
 					startOfMatch = stringToMask[0:partOfMatch[1]]
 				}
 			}
-			maskedSecret := fmt.Sprintf("%s<masked>", startOfMatch)
+			maskedSecret := masker.mask(re, startOfMatch, stringToMask)
 
-			results = append(results, Result{QueryName: "Passwords And Secrets - " + re.QueryName, Line: lineOfSecret, FileName: fileName, Severity: "HIGH"})
+			results = append(results, Result{QueryName: "Passwords And Secrets - " + re.QueryName, Line: lineOfSecret, FileName: fileName, Severity: re.severity()})
 
 			maskedMatchString := strings.Replace(matchString, stringToMask, maskedSecret, 1)
 
@@ -285,21 +357,60 @@ func ReplaceMatches(fileName string, result string, regexs []SecretRegex, allowR
 
 			maskedSecrets = append(maskedSecrets, maskedSecretElement)
 
+			// the replacements below change the first occurrences of the match and of the secret in it
+			kept := keptPrefix(stringToMask, startOfMatch, maskedSecret)
+			matchStart := strings.Index(result, matchString)
+			start := matchStart + strings.Index(matchString, stringToMask) + kept
+			end := start - kept + len(stringToMask)
+			tracker.add(re, tracker.joinedOffset(matchStart, false), tracker.joinedOffset(start, false), tracker.joinedOffset(end, true), result[start:end], maskedSecret[kept:])
+			tracker.edits = append(tracker.edits, edit{start: start, oldLen: end - start, newLen: len(maskedSecret) - kept})
+
 			result = strings.Replace(result, matchString, maskedMatchString, 1)
 
 			groups = re.Regex.FindStringSubmatchIndex(result)
 		}
 	}
-	return result, results, maskedSecrets
+	return result, results, maskedSecrets, tracker.sorted()
+}
+
+// submatch returns the group of the match located by loc, the whole match for a group the regex does not have,
+// and an empty string for a group that did not participate in the match
+func submatch(line string, loc []int, group int) string {
+	if group < 0 || 2*group+1 >= len(loc) {
+		group = 0
+	}
+	if loc[2*group] < 0 {
+		return ""
+	}
+	return line[loc[2*group]:loc[2*group+1]]
 }
 
+// allowed reports whether an allow rule of the lists matches the text. The rules are shared by concurrent scans,
+// so the lists are read as they are, never appended to each other
+func allowed(text string, lists ...[]*regexp.Regexp) bool {
+	for _, allowRules := range lists {
+		for _, allowRule := range allowRules {
+			if allowRule.FindString(text) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keptPrefix is the length of the start of the match left unmasked by the replacement
+func keptPrefix(match, startOfMatch, replacement string) int {
+	if strings.HasPrefix(match, startOfMatch) && strings.HasPrefix(replacement, startOfMatch) {
+		return len(startOfMatch)
+	}
+	return 0
+}
+
+// MaskSecrets masks the content with the embedded rules, which are compiled once
 func MaskSecrets(fileContent string) (string, []maskedSecret.MaskedSecret, error) {
-	// Load regexps
-	rs, allowRs, err := LoadRegexps()
+	scanner, err := DefaultScanner()
 	if err != nil {
 		return "", nil, err
 	}
-
-	maskedResult, _, maskedSecrets := ReplaceMatches("", fileContent, rs, allowRs)
-	return maskedResult, maskedSecrets, nil
+	return scanner.Mask(fileContent)
 }
@@ -0,0 +1,174 @@
+package secrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+const maskedText = "<masked>"
+
+const placeholderPrefix = "<masked:"
+
+var placeholderRegex = regexp.MustCompile(`<masked:[A-Z0-9_]+>`)
+
+var nonPlaceholderChars = regexp.MustCompile(`[^A-Z0-9]+`)
+
+var placeholderNameRegex = regexp.MustCompile(`^[A-Z0-9_]*$`)
+
+// Masker replaces every secret with a placeholder such as <masked:AWS_SECRET_KEY_1> and restores them afterwards.
+// The same secret always gets the same placeholder, so masking the messages of a conversation in order
+// gives stable placeholders. A nil Masker masks with "<masked>" and restores nothing
+type Masker struct {
+	mu           sync.Mutex
+	scanner      *Scanner
+	secrets      map[string]string
+	placeholders map[string]string
+	counts       map[string]int
+	longest      int
+}
+
+func NewMasker() *Masker {
+	return &Masker{
+		secrets:      map[string]string{},
+		placeholders: map[string]string{},
+		counts:       map[string]int{},
+	}
+}
+
+// Mask replaces the secrets of the content with placeholders, remembering the secret of each placeholder
+func (m *Masker) Mask(content string) (string, []maskedSecret.MaskedSecret, error) {
+	if m == nil {
+		return MaskSecrets(content)
+	}
+	scanner := m.scanner
+	if scanner == nil {
+		var err error
+		if scanner, err = DefaultScanner(); err != nil {
+			return "", nil, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := scanner.scan(content, m)
+	return entry.MaskedFile, entry.MaskedSecrets, nil
+}
+
+// Restore replaces the placeholders of the text with their secrets, unknown placeholders are kept
+func (m *Masker) Restore(text string) string {
+	if m == nil || !strings.Contains(text, placeholderPrefix) {
+		return text
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return placeholderRegex.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if secret, ok := m.secrets[placeholder]; ok {
+			return secret
+		}
+		return placeholder
+	})
+}
+
+// Secrets returns the secret of every placeholder handed out so far
+func (m *Masker) Secrets() map[string]string {
+	mapping := map[string]string{}
+	if m == nil {
+		return mapping
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for placeholder, secret := range m.secrets {
+		mapping[placeholder] = secret
+	}
+	return mapping
+}
+
+// mask returns the replacement of a match, prefix is the part of the match kept in clear. The placeholders of
+// secrets are named after their rule and the others after their category, e.g. <masked:EMAIL>
+func (m *Masker) mask(re SecretRegex, prefix, match string) string {
+	name := re.QueryName
+	if re.Category != "" && re.Category != CategorySecret {
+		name = re.Category
+		if m == nil {
+			return prefix + placeholderPrefix + placeholderName(name) + ">"
+		}
+	}
+	if m == nil {
+		return prefix + maskedText
+	}
+	if !strings.HasPrefix(match, prefix) {
+		prefix = ""
+	}
+	return prefix + m.placeholder(name, match[len(prefix):])
+}
+
+func placeholderName(name string) string {
+	return strings.Trim(nonPlaceholderChars.ReplaceAllString(strings.ToUpper(name), "_"), "_")
+}
+
+func (m *Masker) placeholder(ruleName, secret string) string {
+	rule := placeholderName(ruleName)
+	key := rule + "\x00" + secret
+	if placeholder, ok := m.placeholders[key]; ok {
+		return placeholder
+	}
+	m.counts[rule]++
+	placeholder := fmt.Sprintf("%s%s_%d>", placeholderPrefix, rule, m.counts[rule])
+	m.placeholders[key] = placeholder
+	m.secrets[placeholder] = secret
+	if len(placeholder) > m.longest {
+		m.longest = len(placeholder)
+	}
+	return placeholder
+}
+
+// Restorer restores placeholders in text received piece by piece, such as a streamed reply.
+// A trailing piece that may be the start of a placeholder is held back until it is complete
+type Restorer struct {
+	masker  *Masker
+	pending string
+}
+
+func (m *Masker) NewRestorer() *Restorer {
+	return &Restorer{masker: m}
+}
+
+// Write returns the restored text that is ready to be delivered
+func (r *Restorer) Write(piece string) string {
+	text := r.pending + piece
+	r.pending = ""
+	if r.masker == nil {
+		return text
+	}
+	if start := strings.LastIndex(text, "<"); start >= 0 && r.partial(text[start:]) {
+		r.pending = text[start:]
+		text = text[:start]
+	}
+	return r.masker.Restore(text)
+}
+
+// Flush returns the text held back, once no more pieces follow
+func (r *Restorer) Flush() string {
+	text := r.masker.Restore(r.pending)
+	r.pending = ""
+	return text
+}
+
+func (r *Restorer) partial(tail string) bool {
+	if strings.Contains(tail, ">") {
+		return false
+	}
+	r.masker.mu.Lock()
+	longest := r.masker.longest
+	r.masker.mu.Unlock()
+	if len(tail) >= longest {
+		return false
+	}
+	if len(tail) <= len(placeholderPrefix) {
+		return strings.HasPrefix(placeholderPrefix, tail)
+	}
+	return strings.HasPrefix(tail, placeholderPrefix) && placeholderNameRegex.MatchString(tail[len(placeholderPrefix):])
+}
@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+const maskerInput = `provider "aws" {
+  aws_secret_key = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEYa"
+  password       = "hunter2hunter2"
+}
+other_password = "hunter2hunter2"
+`
+
+func TestMaskerRoundTrip(t *testing.T) {
+	masker := NewMasker()
+	masked, maskedSecrets, err := masker.Mask(maskerInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(masked, "hunter2") || strings.Contains(masked, "wJalrXUtnFEMI") {
+		t.Fatalf("Secrets left in the masked content:\n%s", masked)
+	}
+	if len(maskedSecrets) == 0 {
+		t.Fatal("Expected the masked secrets to be reported")
+	}
+	if !strings.Contains(masked, "<masked:GENERIC_PASSWORD_1>") || strings.Contains(masked, "GENERIC_PASSWORD_2") {
+		t.Fatalf("Expected the repeated password to share its placeholder:\n%s", masked)
+	}
+	if restored := masker.Restore(masked); restored != maskerInput {
+		t.Fatalf("Expected the original content, got:\n%s", restored)
+	}
+
+	again, _, err := NewMasker().Mask(maskerInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != masked {
+		t.Fatalf("Placeholders should be stable across maskers:\n%s\n%s", masked, again)
+	}
+	if restored := masker.Restore("keep <masked:UNKNOWN_1> and <masked>"); restored != "keep <masked:UNKNOWN_1> and <masked>" {
+		t.Fatalf("Unknown placeholders should be kept, got %q", restored)
+	}
+}
+
+func TestNilMasker(t *testing.T) {
+	var masker *Masker
+	masked, _, err := masker.Mask(`password = "hunter2hunter2"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masked != "password = <masked>" {
+		t.Fatalf("Expected the plain mask, got %q", masked)
+	}
+	if restored := masker.Restore(masked); restored != masked {
+		t.Fatalf("A nil masker should restore nothing, got %q", restored)
+	}
+}
+
+func TestRestorer(t *testing.T) {
+	masker := NewMasker()
+	masked, _, err := masker.Mask(`password = "hunter2hunter2"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := "Rotate " + strings.TrimPrefix(masked, "password = ") + " now, keep a < b"
+	restorer := masker.NewRestorer()
+	var out strings.Builder
+	for i := 0; i < len(reply); i += 3 {
+		end := i + 3
+		if end > len(reply) {
+			end = len(reply)
+		}
+		out.WriteString(restorer.Write(reply[i:end]))
+	}
+	out.WriteString(restorer.Flush())
+	if expected := `Rotate "hunter2hunter2" now, keep a < b`; out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,222 @@
+package secrets
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// PIICategory is a family of personal data detectors, its name is used in the placeholders, e.g. <masked:EMAIL_1>
+type PIICategory string
+
+const (
+	CategorySecret = "secret"
+
+	PIIEmail      PIICategory = "email"
+	PIIPhone      PIICategory = "phone"
+	PIIIPv4       PIICategory = "ipv4"
+	PIIIPv6       PIICategory = "ipv6"
+	PIICreditCard PIICategory = "credit_card"
+	PIIIBAN       PIICategory = "iban"
+	PIINationalID PIICategory = "national_id"
+)
+
+// piiRule is a detector whose matches are only masked when they pass the validation, such as a Luhn checksum
+type piiRule struct {
+	category PIICategory
+	id       string
+	name     string
+	regex    string
+	keywords []string
+	validate func(string) bool
+}
+
+// piiRules run in order, the more specific rules first so a card number is not masked as a phone number
+var piiRules = []piiRule{
+	{PIIEmail, "pii-email", "Email Address", `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`, []string{"@"}, nil},
+	{PIICreditCard, "pii-credit-card", "Credit Card Number", `\b(?:\d[ -]?){12,18}\d\b`, nil, validCardNumber},
+	{PIIIBAN, "pii-iban", "IBAN", `\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`, nil, validIBAN},
+	{PIINationalID, "pii-us-ssn", "US Social Security Number", `\b\d{3}-\d{2}-\d{4}\b`, nil, validSSN},
+	{PIINationalID, "pii-uk-nino", "UK National Insurance Number",
+		`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`, nil, validNINO},
+	{PIIIPv4, "pii-ipv4", "IPv4 Address", `\b(?:\d{1,3}\.){3}\d{1,3}\b`, nil, validIPv4},
+	{PIIIPv6, "pii-ipv6", "IPv6 Address", `(?i)\b[0-9a-f]{1,4}(?::[0-9a-f]{0,4}){2,7}\b`, []string{":"}, validIPv6},
+	{PIIPhone, "pii-phone", "Phone Number",
+		`\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{1,4}(?:[ .-]?\d{2,4}){2,4}\b|(?:\(\b[2-9]\d{2}\)|\b[2-9]\d{2})[ .-]?\d{3}[ .-]\d{4}\b`,
+		nil, validPhone},
+}
+
+// PIICategories returns every PII category
+func PIICategories() []PIICategory {
+	return []PIICategory{PIIEmail, PIIPhone, PIIIPv4, PIIIPv6, PIICreditCard, PIIIBAN, PIINationalID}
+}
+
+// WithPII returns the rules extended with the PII detectors of the categories, all of them when none is given.
+// A nil RuleSet extends the embedded rules
+func (rs *RuleSet) WithPII(categories ...PIICategory) (*RuleSet, error) {
+	if rs == nil {
+		var err error
+		if rs, err = DefaultRuleSet(); err != nil {
+			return nil, err
+		}
+	}
+	if len(categories) == 0 {
+		categories = PIICategories()
+	}
+	selected := map[PIICategory]bool{}
+	for _, category := range categories {
+		if !validCategory(category) {
+			return nil, fmt.Errorf("unknown PII category %q", category)
+		}
+		selected[category] = true
+	}
+
+	extended := &RuleSet{
+		regexes:      append([]SecretRegex(nil), rs.regexes...),
+		allowRegexes: rs.allowRegexes,
+	}
+	for _, rule := range piiRules {
+		if !selected[rule.category] {
+			continue
+		}
+		extended.regexes = append(extended.regexes, SecretRegex{
+			ID:        rule.id,
+			QueryName: rule.name,
+			Regex:     regexp.MustCompile(rule.regex),
+			Keywords:  rule.keywords,
+			Severity:  "MEDIUM",
+			Category:  string(rule.category),
+			Validate:  rule.validate,
+		})
+	}
+	return extended, nil
+}
+
+func validCategory(category PIICategory) bool {
+	for _, known := range PIICategories() {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+func digits(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// validCardNumber checks the length, the issuer prefix and the Luhn checksum of a card number
+func validCardNumber(match string) bool {
+	number := digits(match)
+	if len(number) < 13 || len(number) > 19 || !knownIssuer(number) {
+		return false
+	}
+	sum := 0
+	for i := range number {
+		digit := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// knownIssuer accepts the prefixes of Visa, Mastercard, American Express, Discover, Diners Club and JCB
+func knownIssuer(number string) bool {
+	prefix := func(length int) int {
+		value := 0
+		for _, c := range number[:length] {
+			value = value*10 + int(c-'0')
+		}
+		return value
+	}
+	switch {
+	case number[0] == '4':
+		return true
+	case prefix(2) >= 51 && prefix(2) <= 55, prefix(4) >= 2221 && prefix(4) <= 2720:
+		return len(number) == 16
+	case prefix(2) == 34, prefix(2) == 37:
+		return len(number) == 15
+	case prefix(4) == 6011, prefix(2) == 65, prefix(3) >= 644 && prefix(3) <= 649:
+		return true
+	case prefix(2) == 36, prefix(2) == 38, prefix(3) >= 300 && prefix(3) <= 305:
+		return len(number) == 14
+	case prefix(4) >= 3528 && prefix(4) <= 3589:
+		return true
+	}
+	return false
+}
+
+// validIBAN checks the length and the mod 97 checksum of an IBAN
+func validIBAN(match string) bool {
+	iban := strings.ReplaceAll(match, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	var numeric strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			numeric.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			numeric.WriteString(fmt.Sprint(c - 'A' + 10))
+		default:
+			return false
+		}
+	}
+	value, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(value, big.NewInt(97)).Int64() == 1
+}
+
+// validSSN rejects the area, group and serial numbers never assigned
+func validSSN(match string) bool {
+	area, group, serial := match[0:3], match[4:6], match[7:11]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// validNINO rejects the prefixes never assigned
+func validNINO(match string) bool {
+	switch strings.ToUpper(match[:2]) {
+	case "BG", "GB", "KN", "NK", "NT", "TN", "ZZ":
+		return false
+	}
+	return true
+}
+
+// validIPv4 skips the loopback and unspecified addresses, which identify nobody
+func validIPv4(match string) bool {
+	ip := net.ParseIP(match)
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+}
+
+// validIPv6 also requires two groups, so C++ scopes such as std::string are not addresses
+func validIPv6(match string) bool {
+	ip := net.ParseIP(match)
+	if ip == nil || ip.To4() != nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return false
+	}
+	groups := 0
+	for _, group := range strings.Split(match, ":") {
+		if group != "" {
+			groups++
+		}
+	}
+	return groups >= 2
+}
+
+func validPhone(match string) bool {
+	count := len(digits(match))
+	return count >= 10 && count <= 15
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPIIDetection(t *testing.T) {
+	rules, err := (*RuleSet)(nil).WithPII()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := NewScanner(rules)
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"contact jane.doe+ops@mail.example.co.uk now", "contact <masked:EMAIL> now"},
+		{"call +44 20 7946 0958 or (415) 555-2671", "call <masked:PHONE> or <masked:PHONE>"},
+		{"version 1.2.3 build 20241017", "version 1.2.3 build 20241017"},
+		{"from 198.51.100.23 and 127.0.0.1", "from <masked:IPV4> and 127.0.0.1"},
+		{"not an address 999.1.1.1", "not an address 999.1.1.1"},
+		{"host 2001:db8:85a3::8a2e:370:7334 port", "host <masked:IPV6> port"},
+		{"std::string at 10:30:00, mac 00:1a:2b:3c:4d:5e", "std::string at 10:30:00, mac 00:1a:2b:3c:4d:5e"},
+		{"card 4111 1111 1111 1111 paid", "card <masked:CREDIT_CARD> paid"},
+		{"card 4111 1111 1111 1112 refused", "card 4111 1111 1111 1112 refused"},
+		{"order 1234567890123", "order 1234567890123"},
+		{"iban DE89 3704 0044 0532 0130 00 ok", "iban <masked:IBAN> ok"},
+		{"iban DE89 3704 0044 0532 0130 01 typo", "iban DE89 3704 0044 0532 0130 01 typo"},
+		{"ssn 123-45-6789 and 000-12-3456", "ssn <masked:NATIONAL_ID> and 000-12-3456"},
+		{"nino AB 12 34 56 C", "nino <masked:NATIONAL_ID>"},
+	}
+	for _, test := range tests {
+		entry, err := scanner.Scan(test.content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.MaskedFile != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, entry.MaskedFile)
+		}
+		for _, finding := range entry.Findings {
+			if finding.Category == CategorySecret || finding.Severity != "MEDIUM" {
+				t.Errorf("Unexpected finding %+v", finding)
+			}
+		}
+	}
+}
+
+func TestPIICategories(t *testing.T) {
+	rules, err := (*RuleSet)(nil).WithPII(PIIEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masker := NewScanner(rules).NewMasker()
+	masked, _, err := masker.Mask("jane@example.com and john@example.com, not 198.51.100.23, jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<masked:EMAIL_1> and <masked:EMAIL_2>, not 198.51.100.23, <masked:EMAIL_1>"; masked != expected {
+		t.Fatalf("Expected %q, got %q", expected, masked)
+	}
+	if restored := masker.Restore(masked); !strings.HasPrefix(restored, "jane@example.com and john@example.com") {
+		t.Fatalf("Unexpected restored content %q", restored)
+	}
+	if _, err := (*RuleSet)(nil).WithPII("passport"); err == nil {
+		t.Fatal("Expected an unknown category to fail")
+	}
+}
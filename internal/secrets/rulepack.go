@@ -0,0 +1,258 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+// RulePack extends the embedded rules with rules, global allow rules and rules to disable by ID.
+// It has the format of regex_rules.json plus the disabledRules list
+type RulePack struct {
+	Rules         []SecretRule `json:"rules"`
+	AllowRules    []AllowRule  `json:"allowRules"`
+	DisabledRules []string     `json:"disabledRules"`
+}
+
+// ParseRulePack decodes a JSON rule pack
+func ParseRulePack(data []byte) (*RulePack, error) {
+	var pack RulePack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("parse rule pack: %w", err)
+	}
+	return &pack, nil
+}
+
+// LoadRulePack reads a JSON rule pack from a file
+func LoadRulePack(path string) (*RulePack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pack, err := ParseRulePack(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pack, nil
+}
+
+// RuleProblem is a rule that cannot be used
+type RuleProblem struct {
+	RuleID   string
+	RuleName string
+	Field    string
+	Err      error
+}
+
+func (p RuleProblem) Error() string {
+	var rule string
+	switch {
+	case p.RuleName != "" && p.RuleID != "":
+		rule = fmt.Sprintf("%s (%s)", p.RuleName, p.RuleID)
+	case p.RuleName != "":
+		rule = p.RuleName
+	case p.RuleID != "":
+		rule = p.RuleID
+	default:
+		rule = "global allow rules"
+	}
+	return fmt.Sprintf("%s: %s: %v", rule, p.Field, p.Err)
+}
+
+// ValidationError reports every problem found in the rules, no rule set is built from invalid rules
+type ValidationError struct {
+	Problems []RuleProblem
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		messages[i] = p.Error()
+	}
+	return fmt.Sprintf("%d invalid secret rules: %s", len(e.Problems), strings.Join(messages, "; "))
+}
+
+// RuleSet is a validated and compiled set of rules, safe for concurrent use.
+// A nil RuleSet stands for the embedded rules
+type RuleSet struct {
+	regexes      []SecretRegex
+	allowRegexes []*regexp.Regexp
+	scannerOnce  sync.Once
+	scanner      *Scanner
+}
+
+var (
+	defaultRuleSet     *RuleSet
+	defaultRuleSetErr  error
+	defaultRuleSetOnce sync.Once
+)
+
+// DefaultRuleSet returns the embedded rules
+func DefaultRuleSet() (*RuleSet, error) {
+	defaultRuleSetOnce.Do(func() {
+		defaultRuleSet, defaultRuleSetErr = NewRuleSet()
+	})
+	return defaultRuleSet, defaultRuleSetErr
+}
+
+// NewRuleSet merges the packs, in order, into the embedded rules. A pack disables rules of the embedded
+// rules and of the packs before it, rules sharing a disabled ID are all disabled
+func NewRuleSet(packs ...*RulePack) (*RuleSet, error) {
+	embedded, err := ParseRulePack(regexRules)
+	if err != nil {
+		return nil, err
+	}
+	rules := embedded.Rules
+	allowRules := embedded.AllowRules
+	for _, pack := range packs {
+		if pack == nil {
+			continue
+		}
+		disabled := map[string]bool{}
+		for _, id := range pack.DisabledRules {
+			disabled[id] = true
+		}
+		var kept []SecretRule
+		for _, rule := range rules {
+			if !disabled[rule.ID] {
+				kept = append(kept, rule)
+			}
+		}
+		rules = append(kept, pack.Rules...)
+		allowRules = append(allowRules, pack.AllowRules...)
+	}
+	return compileRules(rules, allowRules)
+}
+
+var severities = map[string]bool{"CRITICAL": true, "HIGH": true, "MEDIUM": true, "LOW": true, "INFO": true}
+
+func compileRules(rules []SecretRule, allowRules []AllowRule) (*RuleSet, error) {
+	var problems []RuleProblem
+	compile := func(rule SecretRule, field, expr string) *regexp.Regexp {
+		compiled, err := regexp.Compile(expr)
+		if err != nil {
+			problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: field, Err: err})
+		}
+		return compiled
+	}
+
+	ruleSet := &RuleSet{}
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Regex == "" {
+			problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: "rule", Err: fmt.Errorf("name and regex are required")})
+			continue
+		}
+		secretRegex := SecretRegex{
+			ID:          rule.ID,
+			QueryName:   rule.Name,
+			Regex:       compile(rule, "regex", rule.Regex),
+			Multiline:   rule.Multiline,
+			Entropies:   rule.Entropies,
+			Severity:    strings.ToUpper(rule.Severity),
+			SecretGroup: rule.SecretGroup,
+		}
+		if secretRegex.Severity != "" && !severities[secretRegex.Severity] {
+			problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: "severity",
+				Err: fmt.Errorf("unknown severity %q", rule.Severity)})
+		}
+		for _, keyword := range rule.Keywords {
+			secretRegex.Keywords = append(secretRegex.Keywords, strings.ToLower(keyword))
+		}
+		for i, allowRule := range rule.AllowRules {
+			field := fmt.Sprintf("allowRules[%d]", i)
+			target := allowTarget(&secretRegex, allowRule)
+			if target == nil {
+				problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: field + ".target",
+					Err: fmt.Errorf("unknown target %q", allowRule.Target)})
+				continue
+			}
+			*target = append(*target, compile(rule, field, allowRule.Regex))
+		}
+		if rule.SpecialMask != "" {
+			secretRegex.SpecialMask = compile(rule, "specialMask", rule.SpecialMask)
+		}
+		if secretRegex.Regex != nil {
+			groups := secretRegex.Regex.NumSubexp()
+			if rule.Multiline.DetectLineGroup > groups {
+				problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: "multiline.detectLineGroup",
+					Err: fmt.Errorf("group %d not in a regex with %d groups", rule.Multiline.DetectLineGroup, groups)})
+			}
+			if rule.SecretGroup < 0 || rule.SecretGroup > groups {
+				problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: "secretGroup",
+					Err: fmt.Errorf("group %d not in a regex with %d groups", rule.SecretGroup, groups)})
+			}
+			for _, entropy := range rule.Entropies {
+				if entropy.Group > groups {
+					problems = append(problems, RuleProblem{RuleID: rule.ID, RuleName: rule.Name, Field: "entropies.group",
+						Err: fmt.Errorf("group %d not in a regex with %d groups", entropy.Group, groups)})
+				}
+			}
+		}
+		ruleSet.regexes = append(ruleSet.regexes, secretRegex)
+	}
+	for _, allowRule := range allowRules {
+		field := fmt.Sprintf("allow rule %q", allowRule.Description)
+		switch allowRule.Target {
+		case "", AllowTargetLine:
+			ruleSet.allowRegexes = append(ruleSet.allowRegexes, compile(SecretRule{}, field, allowRule.Regex))
+		case AllowTargetMatch, AllowTargetSecret:
+			// the global rules matched against a match or a secret are checked with the rules of each rule
+			compiled := compile(SecretRule{}, field, allowRule.Regex)
+			for i := range ruleSet.regexes {
+				target := allowTarget(&ruleSet.regexes[i], allowRule)
+				*target = append(*target, compiled)
+			}
+		default:
+			problems = append(problems, RuleProblem{Field: field + ".target", Err: fmt.Errorf("unknown target %q", allowRule.Target)})
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return ruleSet, nil
+}
+
+// Scanner returns the scanner of the rules, built once, a nil RuleSet returns the DefaultScanner
+func (rs *RuleSet) Scanner() (*Scanner, error) {
+	if rs == nil {
+		return DefaultScanner()
+	}
+	rs.scannerOnce.Do(func() {
+		rs.scanner = NewScanner(rs)
+	})
+	return rs.scanner, nil
+}
+
+// Mask replaces the secrets found by the rules with "<masked>"
+func (rs *RuleSet) Mask(content string) (string, []maskedSecret.MaskedSecret, error) {
+	scanner, err := rs.Scanner()
+	if err != nil {
+		return "", nil, err
+	}
+	return scanner.Mask(content)
+}
+
+// NewMasker returns a reversible masker using the rules
+func (rs *RuleSet) NewMasker() *Masker {
+	scanner, _ := rs.Scanner()
+	return scanner.NewMasker()
+}
+
+// allowTarget returns the allow rules of the regex the allow rule belongs to, nil for an unknown target
+func allowTarget(secretRegex *SecretRegex, allowRule AllowRule) *[]*regexp.Regexp {
+	switch allowRule.Target {
+	case "", AllowTargetLine:
+		return &secretRegex.AllowRules
+	case AllowTargetMatch:
+		return &secretRegex.MatchAllowRules
+	case AllowTargetSecret:
+		return &secretRegex.SecretAllowRules
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const acmeRulePack = `{
+  "rules": [
+    {
+      "id": "acme-token",
+      "name": "ACME Token",
+      "regex": "acme_[0-9a-f]{32}"
+    }
+  ],
+  "allowRules": [
+    {
+      "description": "Documentation examples",
+      "regex": "EXAMPLE"
+    }
+  ],
+  "disabledRules": ["487f4be7-3fd9-4506-a07a-eae252180c08"]
+}`
+
+func TestRulePack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme.json")
+	if err := os.WriteFile(path, []byte(acmeRulePack), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pack, err := LoadRulePack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleSet, err := NewRuleSet(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "token: acme_0123456789abcdef0123456789abcdef\n" +
+		"doc: acme_0123456789abcdef0123456789abcdef EXAMPLE\n" +
+		"password = \"hunter2hunter2\"\n"
+	masked, maskedSecrets, err := ruleSet.Mask(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(masked, "\n")
+	if !strings.Contains(lines[0], "<masked>") || strings.Contains(lines[0], "acme_") {
+		t.Errorf("Expected the pack rule to mask the token, got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "acme_") {
+		t.Errorf("Expected the global allow rule to keep the example, got %q", lines[1])
+	}
+	if lines[2] != `password = "hunter2hunter2"` {
+		t.Errorf("Expected the disabled rule not to mask, got %q", lines[2])
+	}
+	if len(maskedSecrets) != 1 {
+		t.Errorf("Expected one masked secret, got %v", maskedSecrets)
+	}
+}
+
+func TestRulePackValidation(t *testing.T) {
+	pack, err := ParseRulePack([]byte(`{
+  "rules": [
+    {"id": "broken", "name": "Broken", "regex": "([a-z]+", "specialMask": "[x"},
+    {"id": "groups", "name": "Groups", "regex": "(a)(b)", "multiline": {"detectLineGroup": 3}},
+    {"id": "unnamed", "regex": "x"},
+    {"id": "severity", "name": "Severity", "regex": "x", "severity": "urgent"}
+  ],
+  "allowRules": [{"description": "bad", "regex": "*"}, {"description": "target", "regex": "x", "target": "file"}]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRuleSet(pack)
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if len(validationError.Problems) != 7 {
+		t.Fatalf("Expected every problem to be reported, got %v", validationError)
+	}
+	if validationError.Problems[0].RuleID != "broken" || validationError.Problems[0].Field != "regex" {
+		t.Fatalf("Unexpected first problem %v", validationError.Problems[0])
+	}
+}
+
+func TestDefaultRuleSet(t *testing.T) {
+	ruleSet, err := DefaultRuleSet()
+	if err != nil {
+		t.Fatalf("The embedded rules should be valid: %v", err)
+	}
+	regexes, _, err := LoadRegexps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ruleSet.regexes) != len(regexes) {
+		t.Fatalf("Expected %d rules, got %d", len(regexes), len(ruleSet.regexes))
+	}
+}
@@ -0,0 +1,268 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+const (
+	SarifVersion = "2.1.0"
+	SarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	sarifToolName        = "gen-ai-wrapper secret masking"
+	sarifInformationURI  = "https://github.com/Checkmarx/gen-ai-wrapper"
+	sarifFingerprintName = "secretHmac/v1"
+	// sarifKeySize is the size of the key generated for a report without one
+	sarifKeySize = 32
+)
+
+// SarifLog is the subset of a SARIF 2.1.0 log written for the masked secrets
+type SarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+	Tool       SarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []SarifResult `json:"results"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []SarifRule `json:"rules"`
+}
+
+type SarifRule struct {
+	ID                   string              `json:"id"`
+	Name                 string              `json:"name"`
+	ShortDescription     SarifMessage        `json:"shortDescription"`
+	DefaultConfiguration SarifConfiguration  `json:"defaultConfiguration"`
+	Properties           SarifRuleProperties `json:"properties"`
+}
+
+type SarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+// SarifRuleProperties keeps the rule ID of the rule packs, which several rules may share, and the severity
+// as a security-severity score
+type SarifRuleProperties struct {
+	RuleID           string   `json:"ruleId,omitempty"`
+	Severity         string   `json:"severity"`
+	SecuritySeverity string   `json:"security-severity"`
+	Tags             []string `json:"tags"`
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             SarifMessage      `json:"message"`
+	Locations           []SarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation SarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+	Region           SarifRegion           `json:"region"`
+}
+
+type SarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// SarifRegion columns count unicode code points, as declared by the columnKind of the run
+type SarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+	ByteOffset  int `json:"byteOffset"`
+	ByteLength  int `json:"byteLength"`
+}
+
+var (
+	sarifLevels = map[string]string{"CRITICAL": "error", "HIGH": "error", "MEDIUM": "warning", "LOW": "note", "INFO": "note"}
+	sarifScores = map[string]string{"CRITICAL": "9.5", "HIGH": "8.0", "MEDIUM": "5.5", "LOW": "3.0", "INFO": "0.0"}
+
+	nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// SarifReport collects the findings of masked contents into a SARIF log whose rule catalog lists every rule
+type SarifReport struct {
+	key     []byte
+	rules   []SarifRule
+	indexes map[string]int
+	results []SarifResult
+}
+
+// NewSarifReport builds the rule catalog of the rules, a nil RuleSet uses the embedded rules. The fingerprints
+// of the secrets are keyed with fingerprintKey, so they cannot be brute-forced without it: reports sharing the
+// key give the same secret the same fingerprint. An empty key is replaced by a random one, the fingerprints
+// then only match within the report
+func NewSarifReport(rules *RuleSet, fingerprintKey []byte) (*SarifReport, error) {
+	if rules == nil {
+		var err error
+		if rules, err = DefaultRuleSet(); err != nil {
+			return nil, err
+		}
+	}
+	if len(fingerprintKey) == 0 {
+		fingerprintKey = make([]byte, sarifKeySize)
+		if _, err := rand.Read(fingerprintKey); err != nil {
+			return nil, err
+		}
+	}
+	report := &SarifReport{key: append([]byte{}, fingerprintKey...), indexes: map[string]int{}}
+	ids := map[string]int{}
+	for _, re := range rules.regexes {
+		key := sarifRuleKey(re.ID, re.QueryName)
+		if _, ok := report.indexes[key]; ok {
+			continue
+		}
+		// rules may share an ID, the SARIF IDs come from the unique names
+		id := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(re.QueryName), "-"), "-")
+		ids[id]++
+		if ids[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, ids[id])
+		}
+		report.indexes[key] = len(report.rules)
+		report.rules = append(report.rules, newSarifRule(id, re.ID, re.QueryName, re.severity(), re.category()))
+	}
+	return report, nil
+}
+
+func newSarifRule(id, ruleID, name, severity, category string) SarifRule {
+	return SarifRule{
+		ID:                   id,
+		Name:                 name,
+		ShortDescription:     SarifMessage{Text: name},
+		DefaultConfiguration: SarifConfiguration{Level: sarifLevel(severity)},
+		Properties: SarifRuleProperties{
+			RuleID:           ruleID,
+			Severity:         severity,
+			SecuritySeverity: sarifScores[severity],
+			Tags:             []string{"security", category},
+		},
+	}
+}
+
+// Add records the findings of a content, as returned by Scanner.Scan. The content is needed to compute the
+// columns and the fingerprints, it is not written to the log
+func (r *SarifReport) Add(uri, content string, findings []maskedSecret.Finding) {
+	for _, finding := range findings {
+		key := sarifRuleKey(finding.RuleID, finding.RuleName)
+		index, ok := r.indexes[key]
+		if !ok {
+			// a finding of rules unknown to the catalog, such as rules of another RuleSet
+			index = len(r.rules)
+			r.indexes[key] = index
+			r.rules = append(r.rules, newSarifRule(fmt.Sprintf("rule-%d", index+1), finding.RuleID, finding.RuleName, finding.Severity, finding.Category))
+		}
+		r.results = append(r.results, SarifResult{
+			RuleID:    r.rules[index].ID,
+			RuleIndex: index,
+			Level:     sarifLevel(finding.Severity),
+			Message:   SarifMessage{Text: fmt.Sprintf("%s masked as %s", finding.RuleName, finding.Replacement)},
+			Locations: []SarifLocation{{PhysicalLocation: SarifPhysicalLocation{
+				ArtifactLocation: SarifArtifactLocation{URI: uri},
+				Region: SarifRegion{
+					StartLine:   finding.StartLine,
+					StartColumn: codePointColumn(content, finding.StartOffset, finding.StartColumn),
+					EndLine:     finding.EndLine,
+					EndColumn:   codePointColumn(content, finding.EndOffset, finding.EndColumn),
+					ByteOffset:  finding.StartOffset,
+					ByteLength:  finding.EndOffset - finding.StartOffset,
+				},
+			}}},
+			PartialFingerprints: map[string]string{sarifFingerprintName: sarifFingerprint(r.key, finding, content)},
+		})
+	}
+}
+
+// Log returns the SARIF log of the findings added so far
+func (r *SarifReport) Log() *SarifLog {
+	results := r.results
+	if results == nil {
+		results = []SarifResult{}
+	}
+	return &SarifLog{
+		Version: SarifVersion,
+		Schema:  SarifSchema,
+		Runs: []SarifRun{{
+			Tool: SarifTool{Driver: SarifDriver{
+				Name:           sarifToolName,
+				InformationURI: sarifInformationURI,
+				Rules:          r.rules,
+			}},
+			ColumnKind: "unicodeCodePoints",
+			Results:    results,
+		}},
+	}
+}
+
+// WriteTo writes the indented JSON of the SARIF log
+func (r *SarifReport) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(r.Log(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+func sarifRuleKey(id, name string) string {
+	return id + "\x00" + name
+}
+
+func sarifLevel(severity string) string {
+	if level, ok := sarifLevels[severity]; ok {
+		return level
+	}
+	return "warning"
+}
+
+// codePointColumn converts the byte column of an offset into a column of unicode code points
+func codePointColumn(content string, offset, byteColumn int) int {
+	lineStart := offset - byteColumn + 1
+	if lineStart < 0 || offset > len(content) {
+		return byteColumn
+	}
+	return utf8.RuneCountInString(content[lineStart:offset]) + 1
+}
+
+// sarifFingerprint identifies the secret of the rule without revealing it, so the same secret has the same
+// fingerprint wherever it is found with the same key
+func sarifFingerprint(key []byte, finding maskedSecret.Finding, content string) string {
+	hash := hmac.New(sha256.New, key)
+	hash.Write([]byte(finding.RuleID + "\x00" + finding.RuleName + "\x00"))
+	if finding.StartOffset >= 0 && finding.EndOffset <= len(content) && finding.StartOffset <= finding.EndOffset {
+		hash.Write([]byte(content[finding.StartOffset:finding.EndOffset]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,112 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSarifReport(t *testing.T) {
+	scanner, err := DefaultScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewSarifReport(nil, []byte("dashboard key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{
+		"main.tf":  "résumé password = \"hunter2hunter2\"\n" + privateKey,
+		"other.tf": "password = \"hunter2hunter2\"\npassword = \"other2other2\"\n",
+	}
+	for _, uri := range []string{"main.tf", "other.tf"} {
+		entry, err := scanner.Scan(contents[uri])
+		if err != nil {
+			t.Fatal(err)
+		}
+		report.Add(uri, contents[uri], entry.Findings)
+	}
+
+	var buffer bytes.Buffer
+	if _, err := report.WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buffer.String(), "hunter2") || strings.Contains(buffer.String(), "MIIEpAIBAAKCAQEA") {
+		t.Fatalf("The SARIF log should not contain secrets:\n%s", buffer.String())
+	}
+	var log SarifLog
+	if err := json.Unmarshal(buffer.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != SarifVersion || log.Schema != SarifSchema || len(log.Runs) != 1 {
+		t.Fatalf("Unexpected log %+v", log)
+	}
+	run := log.Runs[0]
+	ruleSet, _ := DefaultRuleSet()
+	if len(run.Tool.Driver.Rules) != len(ruleSet.regexes) {
+		t.Fatalf("Expected a catalog of %d rules, got %d", len(ruleSet.regexes), len(run.Tool.Driver.Rules))
+	}
+	ids := map[string]bool{}
+	for _, rule := range run.Tool.Driver.Rules {
+		if ids[rule.ID] {
+			t.Fatalf("Duplicated rule id %s", rule.ID)
+		}
+		ids[rule.ID] = true
+	}
+
+	if len(run.Results) != 4 {
+		t.Fatalf("Expected 4 results, got %+v", run.Results)
+	}
+	for _, result := range run.Results {
+		if run.Tool.Driver.Rules[result.RuleIndex].ID != result.RuleID || result.Level != "error" {
+			t.Fatalf("Unexpected result %+v", result)
+		}
+	}
+	first, second, third := run.Results[0], run.Results[2], run.Results[3]
+	if first.RuleID != "generic-password" || first.Locations[0].PhysicalLocation.ArtifactLocation.URI != "main.tf" {
+		t.Fatalf("Unexpected first result %+v", first)
+	}
+	region := first.Locations[0].PhysicalLocation.Region
+	if region.StartColumn != region.ByteOffset+1-2 || region.EndColumn-region.StartColumn != region.ByteLength {
+		t.Fatalf("Expected columns in code points, got %+v", region)
+	}
+	if first.PartialFingerprints[sarifFingerprintName] != second.PartialFingerprints[sarifFingerprintName] ||
+		second.PartialFingerprints[sarifFingerprintName] == third.PartialFingerprints[sarifFingerprintName] {
+		t.Fatal("Expected the fingerprints to identify the secrets")
+	}
+}
+
+func TestSarifFingerprintKey(t *testing.T) {
+	const content = `password = "hunter2hunter2"`
+	scanner, err := DefaultScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := scanner.Scan(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := func(key []byte) string {
+		report, err := NewSarifReport(nil, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report.Add("main.tf", content, entry.Findings)
+		return report.Log().Runs[0].Results[0].PartialFingerprints[sarifFingerprintName]
+	}
+
+	unsalted := sha256.Sum256([]byte(entry.Findings[0].RuleID + "\x00" + entry.Findings[0].RuleName + "\x00" + `"hunter2hunter2"`))
+	keyed := fingerprint([]byte("dashboard key"))
+	if keyed == hex.EncodeToString(unsalted[:]) {
+		t.Fatal("Expected the fingerprint to depend on the key")
+	}
+	if keyed != fingerprint([]byte("dashboard key")) || keyed == fingerprint([]byte("other key")) {
+		t.Fatal("Expected the same fingerprint for the same key only")
+	}
+	if random := fingerprint(nil); random == "" || random == fingerprint(nil) {
+		t.Fatal("Expected a random key for reports without one")
+	}
+}
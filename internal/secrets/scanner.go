@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"sync"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+// Scanner masks secrets with compiled rules and a keyword prefilter, so rules only run on the lines
+// containing one of their keywords. It is built once and is safe for concurrent use
+type Scanner struct {
+	rules  *RuleSet
+	filter *prefilter
+}
+
+var (
+	defaultScanner     *Scanner
+	defaultScannerErr  error
+	defaultScannerOnce sync.Once
+)
+
+// NewScanner compiles the keyword prefilter of the rules, nil rules return the DefaultScanner
+func NewScanner(rules *RuleSet) *Scanner {
+	if rules == nil {
+		// a nil Scanner also stands for the default one, its methods report the error of the embedded rules
+		scanner, _ := DefaultScanner()
+		return scanner
+	}
+	return &Scanner{
+		rules:  rules,
+		filter: newPrefilter(rules.regexes),
+	}
+}
+
+// DefaultScanner returns the scanner of the embedded rules
+func DefaultScanner() (*Scanner, error) {
+	defaultScannerOnce.Do(func() {
+		var rules *RuleSet
+		rules, defaultScannerErr = DefaultRuleSet()
+		if defaultScannerErr == nil {
+			defaultScanner, _ = rules.Scanner()
+		}
+	})
+	return defaultScanner, defaultScannerErr
+}
+
+// Mask replaces the secrets of the content with "<masked>"
+func (s *Scanner) Mask(content string) (string, []maskedSecret.MaskedSecret, error) {
+	if s == nil {
+		var err error
+		if s, err = DefaultScanner(); err != nil {
+			return "", nil, err
+		}
+	}
+	entry := s.scan(content, nil)
+	return entry.MaskedFile, entry.MaskedSecrets, nil
+}
+
+// Scan masks the content like Mask and also returns the findings, locating each hidden secret in the content
+func (s *Scanner) Scan(content string) (*maskedSecret.MaskedEntry, error) {
+	if s == nil {
+		var err error
+		if s, err = DefaultScanner(); err != nil {
+			return nil, err
+		}
+	}
+	return s.scan(content, nil), nil
+}
+
+// NewMasker returns a reversible masker using the rules of the scanner
+func (s *Scanner) NewMasker() *Masker {
+	masker := NewMasker()
+	masker.scanner = s
+	return masker
+}
+
+func (s *Scanner) scan(content string, masker *Masker) *maskedSecret.MaskedEntry {
+	masked, maskedSecrets, detections := s.detect(content, masker)
+	return &maskedSecret.MaskedEntry{MaskedFile: masked, MaskedSecrets: maskedSecrets, Findings: findings(detections)}
+}
+
+func (s *Scanner) detect(content string, masker *Masker) (string, []maskedSecret.MaskedSecret, []detection) {
+	masked, _, maskedSecrets, detections := replaceMatches("", content, s.rules.regexes, s.rules.allowRegexes, s.filter, masker)
+	return masked, maskedSecrets, detections
+}
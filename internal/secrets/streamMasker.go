@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+const (
+	// DefaultStreamChunkSize is the number of bytes read before masking
+	DefaultStreamChunkSize = 1 << 20
+	// DefaultStreamOverlap is the number of bytes scanned again with the next chunk, the longest multiline
+	// secret, such as a private key, found across chunks
+	DefaultStreamOverlap = 64 << 10
+)
+
+// StreamMasker masks a reader chunk by chunk, holding at most ChunkSize plus twice Overlap bytes. The end of
+// every chunk is kept and scanned again with the next one, so a secret spanning two chunks is found as long as
+// its match is shorter than Overlap. Chunks end on a line when a line ends within Overlap of the chunk, so
+// only lines longer than Overlap are split. Unlike MaskSecrets, the line endings of the content are kept
+type StreamMasker struct {
+	scanner *Scanner
+	masker  *Masker
+
+	ChunkSize int
+	Overlap   int
+	// OnFinding receives every finding, with offsets and lines in the whole content
+	OnFinding func(maskedSecret.Finding)
+}
+
+// NewStreamMasker masks with "<masked>"
+func (s *Scanner) NewStreamMasker() *StreamMasker {
+	return &StreamMasker{scanner: s, ChunkSize: DefaultStreamChunkSize, Overlap: DefaultStreamOverlap}
+}
+
+// NewStreamMasker masks with the placeholders of the masker, which can restore them afterwards
+func (m *Masker) NewStreamMasker() *StreamMasker {
+	if m == nil {
+		return (*Scanner)(nil).NewStreamMasker()
+	}
+	return &StreamMasker{scanner: m.scanner, masker: m, ChunkSize: DefaultStreamChunkSize, Overlap: DefaultStreamOverlap}
+}
+
+// Mask writes the masked content of r to w
+func (sm *StreamMasker) Mask(w io.Writer, r io.Reader) error {
+	scanner := sm.scanner
+	if scanner == nil {
+		var err error
+		if scanner, err = DefaultScanner(); err != nil {
+			return err
+		}
+	}
+	chunkSize, overlap := sm.ChunkSize, sm.Overlap
+	if chunkSize <= 0 {
+		chunkSize = DefaultStreamChunkSize
+	}
+	if overlap < 0 {
+		overlap = 0
+	}
+
+	position := streamPosition{line: 1, column: 1}
+	var pending []byte
+	buffer := make([]byte, chunkSize+overlap)
+	limit := chunkSize + overlap
+	eof := false
+	for !eof || len(pending) > 0 {
+		for len(pending) < limit && !eof {
+			n, err := r.Read(buffer[:min(len(buffer), limit-len(pending))])
+			pending = append(pending, buffer[:n]...)
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		content := string(pending)
+		_, _, detections := sm.detect(scanner, content)
+		cut := len(content)
+		if !eof {
+			// split lines only when the line does not end within the next chunk either, without overlap the
+			// chunk cannot grow and the line is split right away
+			force := overlap == 0 || limit > chunkSize+overlap
+			if cut = streamCut(content, detections, overlap, force); cut == 0 {
+				limit = chunkSize + 2*overlap
+				continue
+			}
+		}
+		if err := sm.write(w, content[:cut], detections, position); err != nil {
+			return err
+		}
+		position = position.advance(content[:cut])
+		pending = append(pending[:0], content[cut:]...)
+		limit = chunkSize + overlap
+	}
+	return nil
+}
+
+func (sm *StreamMasker) detect(scanner *Scanner, content string) (string, []maskedSecret.MaskedSecret, []detection) {
+	if sm.masker == nil {
+		return scanner.detect(content, nil)
+	}
+	sm.masker.mu.Lock()
+	defer sm.masker.mu.Unlock()
+	return scanner.detect(content, sm.masker)
+}
+
+// streamCut returns where the content is final: at the start of the line before the overlap, and before the
+// matches that do not end there. Without a line to end on, it returns 0 unless force allows to split the line
+func streamCut(content string, detections []detection, overlap int, force bool) int {
+	lineStart := func(offset int) int {
+		return strings.LastIndexByte(content[:offset], '\n') + 1
+	}
+	cut := lineStart(len(content) - overlap)
+	if cut == 0 {
+		if !force {
+			return 0
+		}
+		cut = len(content) - overlap
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, detection := range detections {
+			if detection.matchStart >= cut || detection.EndOffset <= cut {
+				continue
+			}
+			switch start := lineStart(detection.matchStart); {
+			case start > 0:
+				cut = start
+			case !force:
+				return 0
+			case detection.matchStart > 0:
+				cut = detection.matchStart
+			default:
+				// a match longer than the overlap at the start of the content is written whole
+				return detection.EndOffset
+			}
+			changed = true
+		}
+	}
+	return cut
+}
+
+// write writes the final part of the content with the findings in it replaced
+func (sm *StreamMasker) write(w io.Writer, final string, detections []detection, position streamPosition) error {
+	var masked strings.Builder
+	last := 0
+	for _, detection := range detections {
+		if detection.EndOffset > len(final) || detection.StartOffset < last {
+			continue
+		}
+		masked.WriteString(final[last:detection.StartOffset])
+		masked.WriteString(detection.Replacement)
+		last = detection.EndOffset
+		if sm.OnFinding != nil {
+			sm.OnFinding(position.shift(detection.Finding))
+		}
+	}
+	masked.WriteString(final[last:])
+	_, err := io.WriteString(w, masked.String())
+	return err
+}
+
+// streamPosition is the position of the first byte of a chunk in the whole content
+type streamPosition struct {
+	offset int
+	line   int
+	column int
+}
+
+func (p streamPosition) advance(text string) streamPosition {
+	p.offset += len(text)
+	if lines := strings.Count(text, "\n"); lines > 0 {
+		p.line += lines
+		p.column = len(text) - strings.LastIndexByte(text, '\n')
+	} else {
+		p.column += len(text)
+	}
+	return p
+}
+
+func (p streamPosition) shift(finding maskedSecret.Finding) maskedSecret.Finding {
+	if finding.StartLine == 1 {
+		finding.StartColumn += p.column - 1
+	}
+	if finding.EndLine == 1 {
+		finding.EndColumn += p.column - 1
+	}
+	finding.StartLine += p.line - 1
+	finding.EndLine += p.line - 1
+	finding.StartOffset += p.offset
+	finding.EndOffset += p.offset
+	return finding
+}
@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+// streamContent has secrets, of one or several lines, at every distance from the chunk boundaries
+func streamContent() string {
+	var b strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, "log line %d %s\n", i, strings.Repeat("x", i*7%23))
+		switch i % 4 {
+		case 1:
+			fmt.Fprintf(&b, "  password = \"hunter%dhunter%d\"\r\n", i, i)
+		case 3:
+			b.WriteString(privateKey)
+		}
+	}
+	return b.String()
+}
+
+func TestStreamMasker(t *testing.T) {
+	scanner, err := DefaultScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := streamContent()
+	expected, err := scanner.Scan(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMasked := applyFindings(content, expected.Findings)
+
+	for _, chunkSize := range []int{1, 17, 64, 301, 4096} {
+		streamMasker := scanner.NewStreamMasker()
+		streamMasker.ChunkSize = chunkSize
+		streamMasker.Overlap = 256
+		var findings []maskedSecret.Finding
+		streamMasker.OnFinding = func(finding maskedSecret.Finding) {
+			findings = append(findings, finding)
+		}
+		var masked bytes.Buffer
+		if err := streamMasker.Mask(&masked, iotest.HalfReader(strings.NewReader(content))); err != nil {
+			t.Fatal(err)
+		}
+		if masked.String() != expectedMasked {
+			t.Fatalf("Chunks of %d bytes masked differently:\n%s", chunkSize, masked.String())
+		}
+		if !sameFindings(findings, expected.Findings) {
+			t.Fatalf("Chunks of %d bytes found %+v, expected %+v", chunkSize, findings, expected.Findings)
+		}
+	}
+}
+
+// sameFindings compares the findings, the entropies are sums over maps, whose order changes the last digits
+func sameFindings(actual, expected []maskedSecret.Finding) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range actual {
+		if math.Abs(actual[i].Entropy-expected[i].Entropy) > 1e-9 {
+			return false
+		}
+		a, e := actual[i], expected[i]
+		a.Entropy, e.Entropy = 0, 0
+		if a != e {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStreamMaskerPlaceholders(t *testing.T) {
+	masker := NewMasker()
+	streamMasker := masker.NewStreamMasker()
+	streamMasker.ChunkSize = 32
+	streamMasker.Overlap = 256
+	var masked bytes.Buffer
+	if err := streamMasker.Mask(&masked, strings.NewReader(streamContent())); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(masked.String(), "hunter") || !strings.Contains(masked.String(), "<masked:ASYMMETRIC_PRIVATE_KEY_1>") {
+		t.Fatalf("Unexpected masked content:\n%s", masked.String())
+	}
+	if restored := masker.Restore(masked.String()); restored != streamContent() {
+		t.Fatalf("Expected the content to be restored:\n%s", restored)
+	}
+}
+
+func TestStreamMaskerReadError(t *testing.T) {
+	reader := io.MultiReader(strings.NewReader("password = \"hunter2hunter2\"\n"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := defaultStreamMasker(t).Mask(io.Discard, reader); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected the read error, got %v", err)
+	}
+}
+
+func TestStreamMaskerLongLine(t *testing.T) {
+	filler := strings.Repeat("a,", 2000)
+	content := filler + ` password = "hunter2hunter2" ` + filler + "\n"
+	streamMasker := defaultStreamMasker(t)
+	streamMasker.ChunkSize = 64
+	streamMasker.Overlap = 128
+	var masked bytes.Buffer
+	if err := streamMasker.Mask(&masked, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filler + " password = <masked> " + filler + "\n"; masked.String() != expected {
+		t.Fatalf("Unexpected masked line of %d bytes", masked.Len())
+	}
+}
+
+func TestStreamMaskerLongLineWithoutOverlap(t *testing.T) {
+	content := strings.Repeat("x", 100)
+	streamMasker := defaultStreamMasker(t)
+	streamMasker.ChunkSize = 16
+	streamMasker.Overlap = 0
+	var masked bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- streamMasker.Mask(&masked, strings.NewReader(content))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a line longer than the chunk to be split without overlap")
+	}
+	if masked.String() != content {
+		t.Fatalf("Expected the content unchanged, got %q", masked.String())
+	}
+}
+
+func defaultStreamMasker(t *testing.T) *StreamMasker {
+	scanner, err := DefaultScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scanner.NewStreamMasker()
+}
@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/contentfilter"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+)
+
+const streamDone = "[DONE]"
+
+var errStreamDone = errors.New("stream done")
+
+// readEvents reads a text/event-stream body and calls onData with the payload of every event, until the
+// [DONE] event. A body ending without it returns io.ErrUnexpectedEOF
+func readEvents(r io.Reader, onData func(data []byte) error) error {
+	reader := bufio.NewReader(r)
+	var data []byte
+	dispatch := func() error {
+		if data == nil {
+			return nil
+		}
+		payload := data
+		data = nil
+		if string(payload) == streamDone {
+			return errStreamDone
+		}
+		return onData(payload)
+	}
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		var err error
+		switch {
+		case len(line) == 0:
+			err = dispatch()
+		case line[0] == ':':
+			// comment line, used by servers as keep-alive
+		default:
+			field, value, _ := bytes.Cut(line, []byte(":"))
+			value = bytes.TrimPrefix(value, []byte(" "))
+			if string(field) == "data" {
+				if data != nil {
+					data = append(data, '\n')
+				}
+				data = append(data, value...)
+			}
+		}
+		if err == nil && readErr == io.EOF {
+			err = dispatch()
+		}
+		if errors.Is(err, errStreamDone) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			// the body was cut before the end of the stream, the reply is incomplete
+			return io.ErrUnexpectedEOF
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// readChunks decodes every chunk of a streamed completion and assembles the full response
+func readChunks(r io.Reader, onChunk ChunkHandler) (*ChatCompletionResponse, error) {
+	accumulator := newChunkAccumulator()
+	err := readEvents(r, func(data []byte) error {
+		chunk, err := decodeChunk(data)
+		if err != nil {
+			return err
+		}
+		return accumulator.add(chunk, onChunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accumulator.response(), nil
+}
+
+func decodeChunk(data []byte) (*ChatCompletionChunk, error) {
+	var chunk = new(ChatCompletionChunk)
+	err := json.Unmarshal(data, chunk)
+	if err != nil {
+		return nil, err
+	}
+	if chunk.ID == "" && len(chunk.Choices) == 0 && chunk.Usage == nil && chunk.PromptFilterResults == nil {
+		var errorResponse = new(ErrorResponse)
+		if json.Unmarshal(data, errorResponse) == nil && errorResponse.Error.Message != "" {
+			return nil, errors.New(errorResponse.Error.Message)
+		}
+	}
+	return chunk, nil
+}
+
+type chunkAccumulator struct {
+	id            string
+	model         string
+	usage         Usage
+	promptFilters []contentfilter.PromptResults
+	choices       map[int]*Choice
+	contents      map[int]*strings.Builder
+	toolCalls     map[int]map[int]*message.ToolCall
+}
+
+func newChunkAccumulator() *chunkAccumulator {
+	return &chunkAccumulator{
+		choices:   map[int]*Choice{},
+		contents:  map[int]*strings.Builder{},
+		toolCalls: map[int]map[int]*message.ToolCall{},
+	}
+}
+
+func (a *chunkAccumulator) add(chunk *ChatCompletionChunk, onChunk ChunkHandler) error {
+	if chunk.ID != "" {
+		a.id = chunk.ID
+	}
+	if chunk.Model != "" {
+		a.model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		a.usage = *chunk.Usage
+	}
+	a.promptFilters = append(a.promptFilters, chunk.PromptFilterResults...)
+	for _, c := range chunk.Choices {
+		choice, ok := a.choices[c.Index]
+		if !ok {
+			choice = &Choice{Index: c.Index}
+			a.choices[c.Index] = choice
+			a.contents[c.Index] = &strings.Builder{}
+			a.toolCalls[c.Index] = map[int]*message.ToolCall{}
+		}
+		if c.Delta.Role != "" {
+			choice.Message.Role = c.Delta.Role
+		}
+		a.contents[c.Index].WriteString(c.Delta.Content)
+		for _, d := range c.Delta.ToolCalls {
+			a.addToolCall(c.Index, d)
+		}
+		if c.FinishReason != "" {
+			choice.FinishReason = c.FinishReason
+		}
+		// each chunk is annotated on its own, the last annotation covers the end of the reply
+		if c.ContentFilterResults != nil {
+			choice.ContentFilterResults = c.ContentFilterResults
+		}
+	}
+	if onChunk != nil {
+		return onChunk(chunk)
+	}
+	return nil
+}
+
+func (a *chunkAccumulator) addToolCall(choiceIndex int, d message.ToolCallDelta) {
+	call, ok := a.toolCalls[choiceIndex][d.Index]
+	if !ok {
+		call = &message.ToolCall{}
+		a.toolCalls[choiceIndex][d.Index] = call
+	}
+	if d.ID != "" {
+		call.ID = d.ID
+	}
+	if d.Type != "" {
+		call.Type = d.Type
+	}
+	call.Function.Name += d.Function.Name
+	call.Function.Arguments += d.Function.Arguments
+}
+
+func (a *chunkAccumulator) response() *ChatCompletionResponse {
+	response := &ChatCompletionResponse{
+		ID:                  a.id,
+		Model:               a.model,
+		Usage:               a.usage,
+		PromptFilterResults: a.promptFilters,
+	}
+	for index, choice := range a.choices {
+		choice.Message.Content = a.contents[index].String()
+		calls := a.toolCalls[index]
+		for i := 0; i < len(calls); i++ {
+			if call, ok := calls[i]; ok {
+				choice.Message.ToolCalls = append(choice.Message.ToolCalls, *call)
+			}
+		}
+		response.Choices = append(response.Choices, *choice)
+	}
+	sort.Slice(response.Choices, func(i, j int) bool {
+		return response.Choices[i].Index < response.Choices[j].Index
+	})
+	return response
+}
+
+// singleChunk converts a complete response into the equivalent single chunk
+func singleChunk(response *ChatCompletionResponse) *ChatCompletionChunk {
+	chunk := &ChatCompletionChunk{
+		ID:                  response.ID,
+		Model:               response.Model,
+		Usage:               &response.Usage,
+		PromptFilterResults: response.PromptFilterResults,
+	}
+	for _, c := range response.Choices {
+		delta := message.Delta{
+			Role:    c.Message.Role,
+			Content: c.Message.Content,
+		}
+		for i, call := range c.Message.ToolCalls {
+			delta.ToolCalls = append(delta.ToolCalls, message.ToolCallDelta{
+				Index:    i,
+				ID:       call.ID,
+				Type:     call.Type,
+				Function: call.Function,
+			})
+		}
+		chunk.Choices = append(chunk.Choices, ChunkChoice{
+			Index:                c.Index,
+			Delta:                delta,
+			FinishReason:         c.FinishReason,
+			ContentFilterResults: c.ContentFilterResults,
+		})
+	}
+	return chunk
+}
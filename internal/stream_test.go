@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadEvents(t *testing.T) {
+	body := ": keep-alive\r\n\r\nevent: message\r\ndata: first\r\ndata: second\r\n\r\ndata: third\n\ndata: [DONE]\n\ndata: ignored\n\n"
+	var events []string
+	err := readEvents(strings.NewReader(body), func(data []byte) error {
+		events = append(events, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != "first\nsecond" || events[1] != "third" {
+		t.Fatalf("Unexpected events %q", events)
+	}
+}
+
+func TestReadChunksEndedEarly(t *testing.T) {
+	body := `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Enable "}}]}` + "\n\n" +
+		`data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"deletion"}}]}` + "\n\n"
+	var chunks int
+	_, err := readChunks(strings.NewReader(body), func(*ChatCompletionChunk) error {
+		chunks++
+		return nil
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || chunks != 2 {
+		t.Fatalf("Expected the stream cut after 2 chunks to fail, got %v after %d chunks", err, chunks)
+	}
+}
+
+func TestReadChunksError(t *testing.T) {
+	body := `data: {"error":{"message":"The server had an error","type":"server_error"}}` + "\n\n"
+	_, err := readChunks(strings.NewReader(body), nil)
+	if err == nil || err.Error() != "The server had an error" {
+		t.Fatalf("Expected stream error, got %v", err)
+	}
+}
+
+func TestReadChunksToolCalls(t *testing.T) {
+	body := `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_query_description","arguments":""}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"query\":"}}]}}]}
+
+data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"ALB\"}"}}]},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+`
+	response, err := readChunks(strings.NewReader(body), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := response.Choices[0].Message.ToolCalls
+	if len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Function.Name != "get_query_description" || calls[0].Function.Arguments != `{"query":"ALB"}` {
+		t.Fatalf("Unexpected tool calls %+v", calls)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("Unexpected finish reason %s", response.Choices[0].FinishReason)
+	}
+}
+
+func TestReadChunksContentFilter(t *testing.T) {
+	body := strings.Join([]string{
+		`data: {"id":"","choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{"hate":{"filtered":false,"severity":"safe"}}}]}`,
+		`data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"content_filter_results":{"violence":{"filtered":false,"severity":"safe"}}}]}`,
+		`data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"content_filter","content_filter_results":{"violence":{"filtered":true,"severity":"high"}}}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	response, err := readChunks(strings.NewReader(body), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.PromptFilterResults) != 1 {
+		t.Fatalf("Expected prompt filter results, got %+v", response.PromptFilterResults)
+	}
+	choice := response.Choices[0]
+	if choice.FinishReason != FinishReasonContentFilter || !choice.ContentFilterResults.Filtered() {
+		t.Fatalf("Expected the last content filter annotation, got %+v", choice)
+	}
+}
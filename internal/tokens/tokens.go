@@ -0,0 +1,120 @@
+package tokens
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenLoader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Overhead of the chat format, see https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// Cost of an image: a low detail image is a fixed 85 tokens, others depend on their size, which is estimated
+// as a 1024x1024 image of four 512x512 tiles of 170 tokens each
+const (
+	tokensPerLowDetailImage = 85
+	tokensPerImage          = tokensPerLowDetailImage + 4*170
+)
+
+var ErrBudgetExceeded = errors.New("conversation does not fit in the context window of the model")
+
+var (
+	encodingsMutex sync.Mutex
+	encodings      = map[string]*tiktoken.Tiktoken{}
+	loaderOnce     sync.Once
+)
+
+type Counter struct {
+	encoding *tiktoken.Tiktoken
+}
+
+// NewCounter returns a counter using the BPE encoding of the model, the ranks are embedded so no download is needed
+func NewCounter(model string) (*Counter, error) {
+	loaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktokenLoader.NewOfflineLoader())
+	})
+	name := models.Encoding(model)
+
+	encodingsMutex.Lock()
+	defer encodingsMutex.Unlock()
+	encoding, ok := encodings[name]
+	if !ok {
+		var err error
+		encoding, err = tiktoken.GetEncoding(name)
+		if err != nil {
+			return nil, err
+		}
+		encodings[name] = encoding
+	}
+	return &Counter{encoding: encoding}, nil
+}
+
+func (c *Counter) Count(text string) int {
+	return len(c.encoding.Encode(text, nil, nil))
+}
+
+func (c *Counter) CountMessage(m message.Message) int {
+	total := tokensPerMessage + c.Count(m.Role) + c.Count(m.Content)
+	for _, part := range m.Parts {
+		switch {
+		case part.Type == message.PartText:
+			total += c.Count(part.Text)
+		case part.ImageURL != nil && part.ImageURL.Detail == message.DetailLow:
+			total += tokensPerLowDetailImage
+		case part.ImageURL != nil:
+			total += tokensPerImage
+		}
+	}
+	for _, call := range m.ToolCalls {
+		total += c.Count(call.Function.Name) + c.Count(call.Function.Arguments)
+	}
+	return total
+}
+
+// CountMessages returns the prompt tokens of a conversation, including the priming of the reply
+func (c *Counter) CountMessages(messages []message.Message) int {
+	total := tokensPerReply
+	for _, m := range messages {
+		total += c.CountMessage(m)
+	}
+	return total
+}
+
+// Fit drops the oldest turns that are not pinned until the conversation fits in the budget,
+// a turn is pinned when any of its messages is
+func (c *Counter) Fit(messages []message.Message, budget int, pinned func(index int, m message.Message) bool) ([]message.Message, error) {
+	total := c.CountMessages(messages)
+	var fitted []message.Message
+	for start := 0; start < len(messages); {
+		end := message.TurnEnd(messages, start)
+		turn := messages[start:end]
+		start = end
+		if total <= budget || isPinned(turn, end-len(turn), pinned) {
+			fitted = append(fitted, turn...)
+			continue
+		}
+		for _, m := range turn {
+			total -= c.CountMessage(m)
+		}
+	}
+	if total > budget {
+		return nil, ErrBudgetExceeded
+	}
+	return fitted, nil
+}
+
+func isPinned(turn []message.Message, offset int, pinned func(index int, m message.Message) bool) bool {
+	for i, m := range turn {
+		if pinned(offset+i, m) {
+			return true
+		}
+	}
+	return false
+}
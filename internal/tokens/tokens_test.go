@@ -0,0 +1,73 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+)
+
+func TestCount(t *testing.T) {
+	for _, model := range []string{models.GPT4, models.GPT4o} {
+		counter, err := NewCounter(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := counter.Count("hello world"); actual != 2 {
+			t.Errorf("%s: expected 2 tokens, got %d", model, actual)
+		}
+	}
+}
+
+func TestCountImages(t *testing.T) {
+	counter, err := NewCounter(models.GPT4o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := message.Message{Role: role.User, Content: "hello world"}
+	parts := message.Message{Role: role.User, Parts: []message.ContentPart{
+		message.TextPart("hello world"),
+		message.ImageURLPart("https://example.com/a.png", message.DetailLow),
+		message.ImageURLPart("https://example.com/b.png", ""),
+	}}
+	if actual, expected := counter.CountMessage(parts), counter.CountMessage(text)+tokensPerLowDetailImage+tokensPerImage; actual != expected {
+		t.Fatalf("Expected %d tokens, got %d", expected, actual)
+	}
+}
+
+func TestFit(t *testing.T) {
+	counter, err := NewCounter(models.GPT4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []message.Message{
+		{Role: role.System, Content: "You are a helpful assistant"},
+		{Role: role.User, Content: "first question about the result"},
+		{Role: role.Assistant, Content: "first answer about the result"},
+		{Role: role.User, Content: "second question"},
+	}
+	pinned := func(index int, m message.Message) bool {
+		return index == len(messages)-1 || m.Role == role.System
+	}
+
+	fitted, err := counter.Fit(messages, counter.CountMessages(messages), pinned)
+	if err != nil || len(fitted) != len(messages) {
+		t.Fatalf("Conversation within budget should be kept, got %v, %v", fitted, err)
+	}
+
+	budget := counter.CountMessages([]message.Message{messages[0], messages[2], messages[3]})
+	fitted, err = counter.Fit(messages, budget, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) != 3 || fitted[0].Role != role.System || fitted[1].Content != messages[2].Content {
+		t.Fatalf("Expected the oldest user message to be dropped, got %v", fitted)
+	}
+
+	_, err = counter.Fit(messages, counter.CountMessage(messages[3]), pinned)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Expected budget exceeded, got %v", err)
+	}
+}
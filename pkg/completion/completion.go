@@ -0,0 +1,67 @@
+package completion
+
+import (
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/tool"
+)
+
+// Options are the request parameters of a chat completion, unset fields keep the model defaults
+type Options struct {
+	Temperature      *float64       `json:"temperature,omitempty"`
+	TopP             *float64       `json:"top_p,omitempty"`
+	MaxTokens        *int           `json:"max_tokens,omitempty"`
+	Stop             []string       `json:"stop,omitempty"`
+	N                *int           `json:"n,omitempty"`
+	Seed             *int           `json:"seed,omitempty"`
+	PresencePenalty  *float64       `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64       `json:"frequency_penalty,omitempty"`
+	LogitBias        map[string]int `json:"logit_bias,omitempty"`
+	User             string         `json:"user,omitempty"`
+	Tools            []tool.Tool    `json:"tools,omitempty"`
+	ToolChoice       *tool.Choice   `json:"tool_choice,omitempty"`
+}
+
+// Ptr returns a pointer to v, to set the optional fields inline
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Merge returns o with every field set in override replaced
+func (o Options) Merge(override Options) Options {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		o.MaxTokens = override.MaxTokens
+	}
+	if override.Stop != nil {
+		o.Stop = override.Stop
+	}
+	if override.N != nil {
+		o.N = override.N
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	if override.PresencePenalty != nil {
+		o.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		o.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.LogitBias != nil {
+		o.LogitBias = override.LogitBias
+	}
+	if override.User != "" {
+		o.User = override.User
+	}
+	if override.Tools != nil {
+		o.Tools = override.Tools
+	}
+	if override.ToolChoice != nil {
+		o.ToolChoice = override.ToolChoice
+	}
+	return o
+}
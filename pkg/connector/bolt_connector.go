@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltFileName = "cx-gpt.db"
+	// boltOpenTimeout bounds the wait for the lock of a database opened by another process
+	boltOpenTimeout = time.Second
+)
+
+var historiesBucket = []byte("histories")
+
+// BoltConnector keeps every conversation in a single bbolt database file. Saves are transactions and reads
+// run concurrently with them, the file is locked against other processes until Close
+type BoltConnector struct {
+	db *bolt.DB
+}
+
+// NewBoltConnector opens, or creates, the database file, by default cx-gpt.db in the temp directory
+func NewBoltConnector(filePath string) (*BoltConnector, error) {
+	if filePath == "" {
+		filePath = path.Join(os.TempDir(), boltFileName)
+	}
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historiesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltConnector{db: db}, nil
+}
+
+func (c *BoltConnector) HistoryById(id uuid.UUID) ([]message.Message, error) {
+	var history []message.Message
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		history, err = readHistory(tx, id)
+		return err
+	})
+	return history, err
+}
+
+func (c *BoltConnector) DeleteHistory(id uuid.UUID) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(historiesBucket).Delete(boltKey(id))
+	})
+}
+
+func (c *BoltConnector) SaveHistory(id uuid.UUID, history []message.Message) error {
+	bytes, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(historiesBucket).Put(boltKey(id), bytes)
+	})
+}
+
+// UpdateHistory reads, changes and saves the history in one transaction, so concurrent updates are not lost
+func (c *BoltConnector) UpdateHistory(id uuid.UUID, update func([]message.Message) ([]message.Message, error)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		history, err := readHistory(tx, id)
+		if err != nil {
+			return err
+		}
+		if history, err = update(history); err != nil {
+			return err
+		}
+		bytes, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(historiesBucket).Put(boltKey(id), bytes)
+	})
+}
+
+// ListIds returns up to limit conversation ids in order, starting after the cursor, which is empty for the
+// first page. The next cursor is empty once the last page is returned
+func (c *BoltConnector) ListIds(cursor string, limit int) ([]uuid.UUID, string, error) {
+	var ids []uuid.UUID
+	next := ""
+	err := c.db.View(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(historiesBucket).Cursor()
+		key, _ := keys.First()
+		if cursor != "" {
+			key, _ = keys.Seek([]byte(cursor))
+			if key != nil && string(key) == cursor {
+				key, _ = keys.Next()
+			}
+		}
+		for ; key != nil; key, _ = keys.Next() {
+			if limit > 0 && len(ids) == limit {
+				next = ids[len(ids)-1].String()
+				break
+			}
+			id, err := uuid.ParseBytes(key)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return ids, next, nil
+}
+
+// Close releases the database file
+func (c *BoltConnector) Close() error {
+	return c.db.Close()
+}
+
+func readHistory(tx *bolt.Tx, id uuid.UUID) ([]message.Message, error) {
+	bytes := tx.Bucket(historiesBucket).Get(boltKey(id))
+	if bytes == nil {
+		return nil, nil
+	}
+	var history []message.Message
+	if err := json.Unmarshal(bytes, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func boltKey(id uuid.UUID) []byte {
+	return []byte(id.String())
+}
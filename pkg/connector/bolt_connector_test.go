@@ -0,0 +1,129 @@
+package connector
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
+)
+
+func TestBoltConnector(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "conversations.db")
+	connector, err := NewBoltConnector(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ Connector = connector
+
+	id := uuid.New()
+	history, err := connector.HistoryById(id)
+	if err != nil || history != nil {
+		t.Fatalf("Expected no history, got %v, %v", history, err)
+	}
+	saved := []message.Message{{Role: role.User, Content: "hello"}, {Role: role.Assistant, Content: "hi"}}
+	if err := connector.SaveHistory(id, saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the history is durable
+	connector, err = NewBoltConnector(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connector.Close()
+	if _, err := NewBoltConnector(filePath); err == nil {
+		t.Fatal("Expected the database to be locked")
+	}
+	history, err = connector.HistoryById(id)
+	if err != nil || len(history) != 2 || history[1].Content != "hi" {
+		t.Fatalf("Unexpected history %v, %v", history, err)
+	}
+	if err := connector.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ = connector.HistoryById(id); history != nil {
+		t.Fatalf("Expected the history to be deleted, got %v", history)
+	}
+}
+
+func TestBoltConnectorListIds(t *testing.T) {
+	connector, err := NewBoltConnector(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connector.Close()
+
+	var expected []string
+	for i := 0; i < 7; i++ {
+		id := uuid.New()
+		expected = append(expected, id.String())
+		if err := connector.SaveHistory(id, []message.Message{{Role: role.User, Content: "hello"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(expected)
+
+	var listed []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		ids, next, err := connector.ListIds(cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			listed = append(listed, id.String())
+		}
+		if next == "" {
+			if pages != 2 {
+				t.Fatalf("Expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+		cursor = next
+	}
+	if fmt.Sprint(listed) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, listed)
+	}
+}
+
+func TestBoltConnectorConcurrentUpdates(t *testing.T) {
+	connector, err := NewBoltConnector(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connector.Close()
+
+	id := uuid.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := connector.UpdateHistory(id, func(history []message.Message) ([]message.Message, error) {
+				return append(history, message.Message{Role: role.User, Content: fmt.Sprint(i)}), nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := connector.HistoryById(id); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	history, err := connector.HistoryById(id)
+	if err != nil || len(history) != 20 {
+		t.Fatalf("Expected every update to be kept, got %d messages, %v", len(history), err)
+	}
+}
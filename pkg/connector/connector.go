@@ -1,13 +1,148 @@
 package connector
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
 
 	"github.com/google/uuid"
 )
 
+// titleLength is the number of characters of the first user message kept as the default title
+const titleLength = 80
+
 type Connector interface {
 	HistoryById(uuid.UUID) ([]message.Message, error)
 	DeleteHistory(uuid.UUID) error
 	SaveHistory(uuid.UUID, []message.Message) error
 }
+
+// ConversationStore is a Connector that also keeps a metadata record of every conversation, so the
+// conversations can be listed. SaveHistory updates the message count, the timestamps and the default title
+// of the record, DeleteHistory deletes it
+type ConversationStore interface {
+	Connector
+	// MetadataById returns nil for a conversation without a record
+	MetadataById(uuid.UUID) (*ConversationMetadata, error)
+	SaveMetadata(ConversationMetadata) error
+	// ListConversations returns up to limit conversations, the most recently updated first, starting after the
+	// cursor, which is empty for the first page. The next cursor is empty once the last page is returned
+	ListConversations(filter ConversationFilter, cursor string, limit int) ([]ConversationMetadata, string, error)
+}
+
+// MetadataUpdater is a ConversationStore that updates the metadata record of a saved history in a single
+// write, so a reader never sees the record of the history without the update
+type MetadataUpdater interface {
+	ConversationStore
+	// SaveHistoryWithMetadata saves the history like SaveHistory, update changes the record before it is written
+	SaveHistoryWithMetadata(id uuid.UUID, history []message.Message, update func(*ConversationMetadata)) error
+}
+
+// ConversationMetadata describes a conversation without its messages
+type ConversationMetadata struct {
+	ID               uuid.UUID `json:"id"`
+	TenantID         string    `json:"tenantId,omitempty"`
+	Title            string    `json:"title,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	MessageCount     int       `json:"messageCount"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens"`
+}
+
+// ConversationFilter selects the listed conversations, its zero value selects all of them
+type ConversationFilter struct {
+	TenantID string
+	// UpdatedAfter and UpdatedBefore bound the update time, exclusively, when they are set
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+func (f ConversationFilter) matches(metadata ConversationMetadata) bool {
+	return (f.TenantID == "" || metadata.TenantID == f.TenantID) &&
+		(f.UpdatedAfter.IsZero() || metadata.UpdatedAt.After(f.UpdatedAfter)) &&
+		(f.UpdatedBefore.IsZero() || metadata.UpdatedAt.Before(f.UpdatedBefore))
+}
+
+// updateMetadata accounts for a saved history in the record of the conversation
+func updateMetadata(metadata *ConversationMetadata, history []message.Message, now time.Time) {
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = now
+	}
+	metadata.UpdatedAt = now
+	metadata.MessageCount = len(history)
+	if metadata.Title == "" {
+		metadata.Title = DefaultTitle(history)
+	}
+}
+
+// DefaultTitle is the beginning of the first line of the first user message of the history
+func DefaultTitle(history []message.Message) string {
+	for _, m := range history {
+		if m.Role != role.User {
+			continue
+		}
+		content := m.Content
+		for _, part := range m.Parts {
+			if content != "" {
+				break
+			}
+			content = part.Text
+		}
+		content = strings.TrimSpace(content)
+		if line, _, found := strings.Cut(content, "\n"); found {
+			content = strings.TrimSpace(line)
+		}
+		if runes := []rune(content); len(runes) > titleLength {
+			content = strings.TrimSpace(string(runes[:titleLength])) + "…"
+		}
+		if content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+// pageConversations filters, orders and pages the records of a ConversationStore
+func pageConversations(all []ConversationMetadata, filter ConversationFilter, cursor string, limit int) ([]ConversationMetadata, string, error) {
+	var after *ConversationMetadata
+	if cursor != "" {
+		updatedAt, id, found := strings.Cut(cursor, "/")
+		parsedTime, timeErr := time.Parse(time.RFC3339Nano, updatedAt)
+		parsedId, idErr := uuid.Parse(id)
+		if !found || timeErr != nil || idErr != nil {
+			return nil, "", fmt.Errorf("invalid conversations cursor %q", cursor)
+		}
+		after = &ConversationMetadata{ID: parsedId, UpdatedAt: parsedTime}
+	}
+
+	var conversations []ConversationMetadata
+	for _, metadata := range all {
+		if filter.matches(metadata) && (after == nil || listedBefore(*after, metadata)) {
+			conversations = append(conversations, metadata)
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return listedBefore(conversations[i], conversations[j])
+	})
+	if limit <= 0 || len(conversations) <= limit {
+		return conversations, "", nil
+	}
+	conversations = conversations[:limit]
+	last := conversations[limit-1]
+	return conversations, last.UpdatedAt.UTC().Format(time.RFC3339Nano) + "/" + last.ID.String(), nil
+}
+
+// listedBefore orders the conversations from the most recently updated, and by id when updated together
+func listedBefore(a, b ConversationMetadata) bool {
+	if !a.UpdatedAt.Equal(b.UpdatedAt) {
+		return a.UpdatedAt.After(b.UpdatedAt)
+	}
+	return a.ID.String() < b.ID.String()
+}
@@ -4,13 +4,20 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
 
 	"github.com/google/uuid"
 )
 
-const innerDir = "cx-gpt"
+const (
+	innerDir = "cx-gpt"
+	// metadataSuffix names the sidecar file holding the metadata next to the history file
+	metadataSuffix = ".meta.json"
+)
 
 type FileSystemConnector struct {
 	BaseDir string
@@ -54,7 +61,12 @@ func (w FileSystemConnector) HistoryById(id uuid.UUID) ([]message.Message, error
 func (w FileSystemConnector) DeleteHistory(id uuid.UUID) error {
 	filePath := w.getFilePathById(id)
 
-	_, err := os.Stat(filePath)
+	err := os.Remove(w.getMetadataPathById(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err = os.Stat(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -66,6 +78,11 @@ func (w FileSystemConnector) DeleteHistory(id uuid.UUID) error {
 }
 
 func (w FileSystemConnector) SaveHistory(id uuid.UUID, history []message.Message) error {
+	return w.SaveHistoryWithMetadata(id, history, nil)
+}
+
+// SaveHistoryWithMetadata saves the history and its metadata record, changed by update when it is not nil
+func (w FileSystemConnector) SaveHistoryWithMetadata(id uuid.UUID, history []message.Message, update func(*ConversationMetadata)) error {
 	var err error
 	filePath := w.getFilePathById(id)
 
@@ -74,10 +91,111 @@ func (w FileSystemConnector) SaveHistory(id uuid.UUID, history []message.Message
 		return err
 	}
 
-	return w.writeHistory(filePath, bytes)
+	if err = w.writeFile(filePath, bytes, true); err != nil {
+		return err
+	}
+
+	metadata, err := w.MetadataById(id)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = &ConversationMetadata{ID: id}
+	}
+	updateMetadata(metadata, history, time.Now())
+	if update != nil {
+		update(metadata)
+	}
+	return w.SaveMetadata(*metadata)
+}
+
+func (w FileSystemConnector) MetadataById(id uuid.UUID) (*ConversationMetadata, error) {
+	bytes, err := os.ReadFile(w.getMetadataPathById(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metadata ConversationMetadata
+	err = json.Unmarshal(bytes, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+// SaveMetadata replaces the metadata record of the conversation with the id of the record
+func (w FileSystemConnector) SaveMetadata(metadata ConversationMetadata) error {
+	bytes, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return w.writeFile(w.getMetadataPathById(metadata.ID), bytes, true)
 }
 
-func (w FileSystemConnector) writeHistory(filepath string, bytes []byte) error {
+// ListConversations reads every metadata record of the directory. Histories saved by previous versions,
+// without a record, are listed with the time of their file and get a record, so they are read only once.
+// Unreadable or corrupt files are skipped, they do not fail the listing
+func (w FileSystemConnector) ListConversations(filter ConversationFilter, cursor string, limit int) ([]ConversationMetadata, string, error) {
+	entries, err := os.ReadDir(w.getBasePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	recorded := map[string]bool{}
+	for _, entry := range entries {
+		recorded[entry.Name()] = true
+	}
+	var all []ConversationMetadata
+	for _, entry := range entries {
+		id, err := uuid.Parse(strings.TrimSuffix(entry.Name(), metadataSuffix))
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		var metadata *ConversationMetadata
+		switch {
+		case strings.HasSuffix(entry.Name(), metadataSuffix):
+			metadata, err = w.MetadataById(id)
+		case !recorded[entry.Name()+metadataSuffix]:
+			metadata, err = w.legacyMetadata(id, entry)
+		}
+		if err == nil && metadata != nil {
+			all = append(all, *metadata)
+		}
+	}
+
+	return pageConversations(all, filter, cursor, limit)
+}
+
+func (w FileSystemConnector) legacyMetadata(id uuid.UUID, entry os.DirEntry) (*ConversationMetadata, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	history, err := w.HistoryById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &ConversationMetadata{ID: id}
+	updateMetadata(metadata, history, info.ModTime())
+	// the record is only created, a record written by a save in the meantime is newer
+	if bytes, err := json.Marshal(metadata); err == nil {
+		_ = w.writeFile(w.getMetadataPathById(id), bytes, false)
+	}
+	return metadata, nil
+}
+
+// writeFile writes a temporary file renamed to the file, so readers see either the previous or the new content.
+// Without replace an existing file is kept
+func (w FileSystemConnector) writeFile(filePath string, bytes []byte, replace bool) error {
 	var err error
 
 	_, err = os.Stat(w.getBasePath())
@@ -92,13 +210,36 @@ func (w FileSystemConnector) writeHistory(filepath string, bytes []byte) error {
 		}
 	}
 
-	return os.WriteFile(filepath, bytes, 0644)
+	// the temporary file name is not a conversation id, so a listing skips it
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(bytes)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if !replace {
+		return os.Link(file.Name(), filePath)
+	}
+	return os.Rename(file.Name(), filePath)
 }
 
 func (w FileSystemConnector) getFilePathById(id uuid.UUID) string {
 	return path.Join(w.getBasePath(), id.String())
 }
 
+func (w FileSystemConnector) getMetadataPathById(id uuid.UUID) string {
+	return w.getFilePathById(id) + metadataSuffix
+}
+
 func (w FileSystemConnector) getBasePath() string {
 	return path.Join(w.BaseDir, innerDir)
 }
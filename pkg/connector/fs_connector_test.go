@@ -0,0 +1,220 @@
+package connector
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
+)
+
+func TestFileSystemConnectorMetadata(t *testing.T) {
+	var store ConversationStore = FileSystemConnector{BaseDir: t.TempDir()}
+
+	id := uuid.New()
+	history := []message.Message{
+		{Role: role.System, Content: "You are a bot"},
+		{Role: role.User, Content: "How can I fix this issue?\nThe issue is in line 7"},
+	}
+	if err := store.SaveHistory(id, history); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := store.MetadataById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata == nil || metadata.ID != id || metadata.MessageCount != 2 || metadata.Title != "How can I fix this issue?" ||
+		metadata.CreatedAt.IsZero() || !metadata.UpdatedAt.Equal(metadata.CreatedAt) {
+		t.Fatalf("Unexpected metadata %+v", metadata)
+	}
+
+	// the fields set by the caller are kept by the next save
+	metadata.Title = "Renamed"
+	metadata.Model = "gpt-4"
+	metadata.TotalTokens = 16
+	if err := store.SaveMetadata(*metadata); err != nil {
+		t.Fatal(err)
+	}
+	createdAt := metadata.CreatedAt
+	if err := store.SaveHistory(id, append(history, message.Message{Role: role.Assistant, Content: "Enable it"})); err != nil {
+		t.Fatal(err)
+	}
+	metadata, _ = store.MetadataById(id)
+	if metadata.Title != "Renamed" || metadata.Model != "gpt-4" || metadata.TotalTokens != 16 || metadata.MessageCount != 3 ||
+		!metadata.CreatedAt.Equal(createdAt) || metadata.UpdatedAt.Before(createdAt) {
+		t.Fatalf("Unexpected metadata %+v", metadata)
+	}
+
+	if err := store.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ = store.MetadataById(id); metadata != nil {
+		t.Fatalf("Expected the metadata to be deleted, got %+v", metadata)
+	}
+}
+
+func TestFileSystemConnectorListConversations(t *testing.T) {
+	store := FileSystemConnector{BaseDir: t.TempDir()}
+	if conversations, next, err := store.ListConversations(ConversationFilter{}, "", 10); err != nil || conversations != nil || next != "" {
+		t.Fatalf("Expected no conversations, got %v, %q, %v", conversations, next, err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		id := uuid.New()
+		ids = append(ids, id)
+		if err := store.SaveHistory(id, []message.Message{{Role: role.User, Content: "hello"}}); err != nil {
+			t.Fatal(err)
+		}
+		tenant := "tenant-a"
+		if i%2 == 1 {
+			tenant = "tenant-b"
+		}
+		metadata, _ := store.MetadataById(id)
+		metadata.TenantID = tenant
+		metadata.UpdatedAt = start.Add(time.Duration(i) * time.Hour)
+		if err := store.SaveMetadata(*metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the pages follow each other, the most recently updated first
+	var listed []uuid.UUID
+	cursor := ""
+	for pages := 0; ; pages++ {
+		conversations, next, err := store.ListConversations(ConversationFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range conversations {
+			listed = append(listed, c.ID)
+		}
+		if next == "" {
+			if pages != 2 {
+				t.Fatalf("Expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+		cursor = next
+	}
+	if len(listed) != 5 {
+		t.Fatalf("Expected 5 conversations, got %v", listed)
+	}
+	for i, id := range listed {
+		if id != ids[4-i] {
+			t.Fatalf("Unexpected order %v", listed)
+		}
+	}
+
+	conversations, _, err := store.ListConversations(ConversationFilter{
+		TenantID:      "tenant-a",
+		UpdatedAfter:  start,
+		UpdatedBefore: start.Add(10 * time.Hour),
+	}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 2 || conversations[0].ID != ids[4] || conversations[1].ID != ids[2] {
+		t.Fatalf("Unexpected filtered conversations %+v", conversations)
+	}
+
+	if _, _, err := store.ListConversations(ConversationFilter{}, "invalid", 2); err == nil {
+		t.Fatal("Expected an invalid cursor error")
+	}
+}
+
+func TestFileSystemConnectorListsLegacyHistories(t *testing.T) {
+	store := FileSystemConnector{BaseDir: t.TempDir()}
+	id := uuid.New()
+	if err := os.MkdirAll(store.getBasePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `[{"role":"user","content":"Explain the found result"},{"role":"assistant","content":"It is disabled"}]`
+	if err := os.WriteFile(path.Join(store.getBasePath(), id.String()), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conversations, _, err := store.ListConversations(ConversationFilter{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 || conversations[0].ID != id || conversations[0].MessageCount != 2 ||
+		conversations[0].Title != "Explain the found result" || conversations[0].UpdatedAt.IsZero() {
+		t.Fatalf("Unexpected conversations %+v", conversations)
+	}
+	// the legacy history gets a record on its first listing
+	if metadata, err := store.MetadataById(id); err != nil || metadata == nil || !metadata.UpdatedAt.Equal(conversations[0].UpdatedAt) {
+		t.Fatalf("Expected the listing to record the legacy history, got %+v, %v", metadata, err)
+	}
+}
+
+func TestFileSystemConnectorListSkipsCorruptFiles(t *testing.T) {
+	store := FileSystemConnector{BaseDir: t.TempDir()}
+	id := uuid.New()
+	if err := store.SaveHistory(id, []message.Message{{Role: role.User, Content: "hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	corruptMetadata, corruptHistory := uuid.New(), uuid.New()
+	if err := os.WriteFile(store.getMetadataPathById(corruptMetadata), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.getFilePathById(corruptHistory), []byte("["), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conversations, _, err := store.ListConversations(ConversationFilter{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 || conversations[0].ID != id {
+		t.Fatalf("Expected only the readable conversation, got %+v", conversations)
+	}
+}
+
+func TestFileSystemConnectorSaveHistoryWithMetadata(t *testing.T) {
+	var store MetadataUpdater = FileSystemConnector{BaseDir: t.TempDir()}
+	id := uuid.New()
+	err := store.SaveHistoryWithMetadata(id, []message.Message{{Role: role.User, Content: "hello"}}, func(metadata *ConversationMetadata) {
+		metadata.TotalTokens += 12
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := store.MetadataById(id); metadata == nil || metadata.TotalTokens != 12 || metadata.MessageCount != 1 {
+		t.Fatalf("Expected the update in the saved metadata, got %+v", metadata)
+	}
+
+	// the files are written through temporary files, which are not left behind
+	entries, err := os.ReadDir(store.(FileSystemConnector).getBasePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected the history and its metadata, got %v", entries)
+	}
+}
+
+func TestDefaultTitle(t *testing.T) {
+	long := ""
+	for i := 0; i < 20; i++ {
+		long += "word "
+	}
+	cases := []struct {
+		history []message.Message
+		title   string
+	}{
+		{nil, ""},
+		{[]message.Message{{Role: role.System, Content: "system"}, {Role: role.User, Content: "  question  "}}, "question"},
+		{[]message.Message{{Role: role.User, Parts: []message.ContentPart{message.ImageURLPart("https://example.com/a.png", ""), message.TextPart("what is this?")}}}, "what is this?"},
+		{[]message.Message{{Role: role.User, Content: long}}, long[:titleLength-1] + "…"},
+	}
+	for _, c := range cases {
+		if title := DefaultTitle(c.history); title != c.title {
+			t.Errorf("Expected title %q, got %q", c.title, title)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package connector
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+
+	"github.com/google/uuid"
+)
+
+// MemoryOption customizes a MemoryConnector when it is created
+type MemoryOption func(*MemoryConnector)
+
+// WithTTL expires a conversation that was neither saved nor read for the duration
+func WithTTL(ttl time.Duration) MemoryOption {
+	return func(c *MemoryConnector) {
+		c.ttl = ttl
+	}
+}
+
+// WithMaxConversations evicts the least recently used conversation beyond max conversations
+func WithMaxConversations(max int) MemoryOption {
+	return func(c *MemoryConnector) {
+		c.maxConversations = max
+	}
+}
+
+// WithJanitorInterval sets how often the expired conversations are removed, by default every TTL
+func WithJanitorInterval(interval time.Duration) MemoryOption {
+	return func(c *MemoryConnector) {
+		c.janitorInterval = interval
+	}
+}
+
+// WithReadThrough makes the MemoryConnector a cache of the connector: missing conversations are read from it,
+// saves and deletes are written to it before the cache. Expired or evicted conversations stay in the connector
+func WithReadThrough(backend Connector) MemoryOption {
+	return func(c *MemoryConnector) {
+		c.backend = backend
+	}
+}
+
+// MemoryConnector keeps the conversations in memory and is safe for concurrent use. Close stops the janitor
+// removing the expired conversations
+type MemoryConnector struct {
+	ttl              time.Duration
+	maxConversations int
+	janitorInterval  time.Duration
+	backend          Connector
+	now              func() time.Time
+
+	mu            sync.Mutex
+	conversations map[uuid.UUID]*list.Element
+	// recent orders the conversations from the most to the least recently used
+	recent *list.List
+	// reads are the backend reads in progress, a save or a delete during a read makes it stale
+	reads map[uuid.UUID]*backendRead
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+type memoryEntry struct {
+	id      uuid.UUID
+	history []message.Message
+	expires time.Time
+}
+
+// backendRead counts the reads of a conversation from the backend, a stale read is not cached
+type backendRead struct {
+	count int
+	stale bool
+}
+
+func NewMemoryConnector(opts ...MemoryOption) *MemoryConnector {
+	c := &MemoryConnector{
+		now:           time.Now,
+		conversations: map[uuid.UUID]*list.Element{},
+		recent:        list.New(),
+		reads:         map[uuid.UUID]*backendRead{},
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.ttl > 0 {
+		if c.janitorInterval <= 0 {
+			c.janitorInterval = c.ttl
+		}
+		go c.janitor()
+	} else {
+		close(c.stopped)
+	}
+	return c
+}
+
+func (c *MemoryConnector) HistoryById(id uuid.UUID) ([]message.Message, error) {
+	c.mu.Lock()
+	if element, ok := c.conversations[id]; ok {
+		entry := element.Value.(*memoryEntry)
+		if !c.expired(entry) {
+			c.touch(element)
+			history := copyHistory(entry.history)
+			c.mu.Unlock()
+			return history, nil
+		}
+		c.remove(element)
+	}
+	if c.backend == nil {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	read := c.reads[id]
+	if read == nil {
+		read = &backendRead{}
+		c.reads[id] = read
+	}
+	read.count++
+	c.mu.Unlock()
+
+	history, err := c.backend.HistoryById(id)
+	if err != nil || history == nil {
+		c.mu.Lock()
+		c.endRead(id, read)
+		c.mu.Unlock()
+		return history, err
+	}
+	return c.load(id, history, read), nil
+}
+
+func (c *MemoryConnector) DeleteHistory(id uuid.UUID) error {
+	if c.backend != nil {
+		if err := c.backend.DeleteHistory(id); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.conversations[id]; ok {
+		c.remove(element)
+	}
+	c.markStale(id)
+	return nil
+}
+
+func (c *MemoryConnector) SaveHistory(id uuid.UUID, history []message.Message) error {
+	if c.backend != nil {
+		if err := c.backend.SaveHistory(id, history); err != nil {
+			return err
+		}
+	}
+	c.store(id, history)
+	return nil
+}
+
+// Len returns the number of conversations in memory, including the expired ones the janitor did not remove yet
+func (c *MemoryConnector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// Close stops the janitor, the conversations stay readable
+func (c *MemoryConnector) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.stopped
+	return nil
+}
+
+func (c *MemoryConnector) store(id uuid.UUID, history []message.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.markStale(id)
+	if element, ok := c.conversations[id]; ok {
+		element.Value.(*memoryEntry).history = copyHistory(history)
+		c.touch(element)
+		return
+	}
+	entry := &memoryEntry{id: id, history: copyHistory(history)}
+	c.conversations[id] = c.recent.PushFront(entry)
+	c.touch(c.conversations[id])
+	c.evict()
+}
+
+// evict removes the least recently used conversations beyond the maximum
+func (c *MemoryConnector) evict() {
+	for c.maxConversations > 0 && c.recent.Len() > c.maxConversations {
+		c.remove(c.recent.Back())
+	}
+}
+
+// load caches the history read from the backend, unless a save or a delete made the read stale, and returns
+// the cached history, or the history read when a delete left nothing to return from the cache
+func (c *MemoryConnector) load(id uuid.UUID, history []message.Message, read *backendRead) []message.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endRead(id, read)
+	if element, ok := c.conversations[id]; ok {
+		if entry := element.Value.(*memoryEntry); !c.expired(entry) {
+			c.touch(element)
+			return copyHistory(entry.history)
+		}
+		c.remove(element)
+	}
+	if read.stale {
+		return history
+	}
+	entry := &memoryEntry{id: id, history: copyHistory(history)}
+	c.conversations[id] = c.recent.PushFront(entry)
+	c.touch(c.conversations[id])
+	c.evict()
+	return history
+}
+
+// markStale keeps the backend reads of the conversation in progress from caching what they read
+func (c *MemoryConnector) markStale(id uuid.UUID) {
+	if read, ok := c.reads[id]; ok {
+		read.stale = true
+	}
+}
+
+func (c *MemoryConnector) endRead(id uuid.UUID, read *backendRead) {
+	if read.count--; read.count == 0 {
+		delete(c.reads, id)
+	}
+}
+
+// touch marks the conversation as used now
+func (c *MemoryConnector) touch(element *list.Element) {
+	if c.ttl > 0 {
+		element.Value.(*memoryEntry).expires = c.now().Add(c.ttl)
+	}
+	c.recent.MoveToFront(element)
+}
+
+func (c *MemoryConnector) expired(entry *memoryEntry) bool {
+	return c.ttl > 0 && !c.now().Before(entry.expires)
+}
+
+func (c *MemoryConnector) remove(element *list.Element) {
+	delete(c.conversations, element.Value.(*memoryEntry).id)
+	c.recent.Remove(element)
+}
+
+func (c *MemoryConnector) janitor() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(c.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
+
+func (c *MemoryConnector) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for element := c.recent.Back(); element != nil; {
+		previous := element.Prev()
+		if c.expired(element.Value.(*memoryEntry)) {
+			c.remove(element)
+		}
+		element = previous
+	}
+}
+
+// copyHistory keeps the stored history apart from the slices of the caller, including the parts and the tool
+// calls of its messages
+func copyHistory(history []message.Message) []message.Message {
+	if history == nil {
+		return nil
+	}
+	copied := append([]message.Message{}, history...)
+	for i, m := range copied {
+		if m.Parts != nil {
+			copied[i].Parts = append([]message.ContentPart{}, m.Parts...)
+			for j, part := range copied[i].Parts {
+				if part.ImageURL != nil {
+					imageURL := *part.ImageURL
+					copied[i].Parts[j].ImageURL = &imageURL
+				}
+			}
+		}
+		if m.ToolCalls != nil {
+			copied[i].ToolCalls = append([]message.ToolCall{}, m.ToolCalls...)
+		}
+	}
+	return copied
+}
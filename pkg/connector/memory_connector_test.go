@@ -0,0 +1,298 @@
+package connector
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
+)
+
+func TestMemoryConnector(t *testing.T) {
+	connector := NewMemoryConnector()
+	defer connector.Close()
+	var _ Connector = connector
+
+	id := uuid.New()
+	history, err := connector.HistoryById(id)
+	if err != nil || history != nil {
+		t.Fatalf("Expected no history, got %v, %v", history, err)
+	}
+	saved := []message.Message{{Role: role.User, Content: "hello"}}
+	if err := connector.SaveHistory(id, saved); err != nil {
+		t.Fatal(err)
+	}
+	// the stored history is a copy
+	saved[0].Content = "changed"
+	history, err = connector.HistoryById(id)
+	if err != nil || len(history) != 1 || history[0].Content != "hello" {
+		t.Fatalf("Unexpected history %v, %v", history, err)
+	}
+	history[0].Content = "changed"
+	if history, _ = connector.HistoryById(id); history[0].Content != "hello" {
+		t.Fatalf("Expected the read history to be a copy, got %v", history)
+	}
+	if err := connector.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ = connector.HistoryById(id); history != nil {
+		t.Fatalf("Expected the history to be deleted, got %v", history)
+	}
+}
+
+func TestMemoryConnectorTTL(t *testing.T) {
+	now := time.Now()
+	connector := NewMemoryConnector(WithTTL(time.Minute), WithJanitorInterval(time.Hour))
+	defer connector.Close()
+	connector.now = func() time.Time { return now }
+
+	first, second := uuid.New(), uuid.New()
+	_ = connector.SaveHistory(first, []message.Message{{Role: role.User, Content: "first"}})
+	_ = connector.SaveHistory(second, []message.Message{{Role: role.User, Content: "second"}})
+
+	// reading the first conversation extends its TTL
+	now = now.Add(40 * time.Second)
+	if history, _ := connector.HistoryById(first); history == nil {
+		t.Fatal("Expected the first conversation")
+	}
+	now = now.Add(40 * time.Second)
+	if history, _ := connector.HistoryById(second); history != nil {
+		t.Fatalf("Expected the second conversation to expire, got %v", history)
+	}
+	if history, _ := connector.HistoryById(first); history == nil {
+		t.Fatal("Expected the first conversation")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if connector.Len() != 1 {
+		t.Fatalf("Expected 1 conversation before the janitor runs, got %d", connector.Len())
+	}
+	connector.removeExpired()
+	if connector.Len() != 0 {
+		t.Fatalf("Expected the janitor to remove the expired conversation, got %d", connector.Len())
+	}
+}
+
+func TestMemoryConnectorJanitor(t *testing.T) {
+	connector := NewMemoryConnector(WithTTL(time.Millisecond), WithJanitorInterval(time.Millisecond))
+	_ = connector.SaveHistory(uuid.New(), []message.Message{{Role: role.User, Content: "hello"}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for connector.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if connector.Len() != 0 {
+		t.Fatal("Expected the janitor to remove the expired conversation")
+	}
+
+	if err := connector.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-connector.stopped:
+	default:
+		t.Fatal("Expected Close to stop the janitor")
+	}
+	// closing twice is fine
+	if err := connector.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryConnectorLRU(t *testing.T) {
+	connector := NewMemoryConnector(WithMaxConversations(2))
+	defer connector.Close()
+
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	_ = connector.SaveHistory(ids[0], []message.Message{{Role: role.User, Content: "0"}})
+	_ = connector.SaveHistory(ids[1], []message.Message{{Role: role.User, Content: "1"}})
+	// the first conversation becomes the most recently used
+	_, _ = connector.HistoryById(ids[0])
+	_ = connector.SaveHistory(ids[2], []message.Message{{Role: role.User, Content: "2"}})
+
+	if connector.Len() != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", connector.Len())
+	}
+	if history, _ := connector.HistoryById(ids[1]); history != nil {
+		t.Fatalf("Expected the least recently used conversation to be evicted, got %v", history)
+	}
+	for _, id := range []uuid.UUID{ids[0], ids[2]} {
+		if history, _ := connector.HistoryById(id); history == nil {
+			t.Fatalf("Expected conversation %s", id)
+		}
+	}
+}
+
+// countingConnector counts the reads of the connector it wraps
+type countingConnector struct {
+	Connector
+	mu    sync.Mutex
+	reads int
+}
+
+func (c *countingConnector) HistoryById(id uuid.UUID) ([]message.Message, error) {
+	c.mu.Lock()
+	c.reads++
+	c.mu.Unlock()
+	return c.Connector.HistoryById(id)
+}
+
+func TestMemoryConnectorReadThrough(t *testing.T) {
+	backend := &countingConnector{Connector: NewMemoryConnector()}
+	cache := NewMemoryConnector(WithReadThrough(backend), WithMaxConversations(1))
+	defer cache.Close()
+
+	id, other := uuid.New(), uuid.New()
+	_ = backend.SaveHistory(id, []message.Message{{Role: role.User, Content: "stored"}})
+
+	for i := 0; i < 2; i++ {
+		history, err := cache.HistoryById(id)
+		if err != nil || len(history) != 1 || history[0].Content != "stored" {
+			t.Fatalf("Unexpected history %v, %v", history, err)
+		}
+	}
+	if backend.reads != 1 {
+		t.Fatalf("Expected 1 read of the backend, got %d", backend.reads)
+	}
+
+	// saves are written through, evicted conversations are read again from the backend
+	_ = cache.SaveHistory(other, []message.Message{{Role: role.User, Content: "other"}})
+	if history, _ := backend.Connector.HistoryById(other); history == nil {
+		t.Fatal("Expected the save to be written to the backend")
+	}
+	if history, _ := cache.HistoryById(id); history == nil || backend.reads != 2 {
+		t.Fatalf("Expected the evicted conversation from the backend, got %v after %d reads", history, backend.reads)
+	}
+
+	if err := cache.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := backend.Connector.HistoryById(id); history != nil {
+		t.Fatal("Expected the delete to be written to the backend")
+	}
+}
+
+func TestMemoryConnectorConcurrency(t *testing.T) {
+	connector := NewMemoryConnector(WithTTL(time.Minute), WithMaxConversations(8), WithJanitorInterval(time.Millisecond))
+	defer connector.Close()
+
+	ids := make([]uuid.UUID, 16)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				id := ids[(i+j)%len(ids)]
+				_ = connector.SaveHistory(id, []message.Message{{Role: role.User, Content: "hello"}})
+				_, _ = connector.HistoryById(id)
+				if j%10 == 0 {
+					_ = connector.DeleteHistory(id)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if connector.Len() > 8 {
+		t.Fatalf("Expected at most 8 conversations, got %d", connector.Len())
+	}
+}
+
+// blockingConnector holds the reads of the connector it wraps until they are released
+type blockingConnector struct {
+	Connector
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingConnector) HistoryById(id uuid.UUID) ([]message.Message, error) {
+	history, err := c.Connector.HistoryById(id)
+	c.reading <- struct{}{}
+	<-c.release
+	return history, err
+}
+
+func TestMemoryConnectorReadThroughKeepsConcurrentSave(t *testing.T) {
+	backend := &blockingConnector{Connector: NewMemoryConnector(), reading: make(chan struct{}), release: make(chan struct{})}
+	cache := NewMemoryConnector(WithReadThrough(backend))
+	defer cache.Close()
+
+	id := uuid.New()
+	_ = backend.SaveHistory(id, []message.Message{{Role: role.User, Content: "old"}})
+	read := make(chan []message.Message)
+	go func() {
+		history, _ := cache.HistoryById(id)
+		read <- history
+	}()
+
+	// the save lands while the backend read is in progress
+	<-backend.reading
+	if err := cache.SaveHistory(id, []message.Message{{Role: role.User, Content: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.release)
+	if history := <-read; len(history) != 1 || history[0].Content != "new" {
+		t.Fatalf("Expected the saved history, got %v", history)
+	}
+	if history, _ := cache.HistoryById(id); len(history) != 1 || history[0].Content != "new" {
+		t.Fatalf("Expected the read not to replace the saved history, got %v", history)
+	}
+}
+
+func TestMemoryConnectorReadThroughKeepsConcurrentDelete(t *testing.T) {
+	backend := &blockingConnector{Connector: NewMemoryConnector(), reading: make(chan struct{}), release: make(chan struct{})}
+	cache := NewMemoryConnector(WithReadThrough(backend))
+	defer cache.Close()
+
+	id := uuid.New()
+	_ = backend.SaveHistory(id, []message.Message{{Role: role.User, Content: "old"}})
+	read := make(chan []message.Message)
+	go func() {
+		history, _ := cache.HistoryById(id)
+		read <- history
+	}()
+
+	// the delete lands while the backend read is in progress
+	<-backend.reading
+	if err := cache.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	close(backend.release)
+	if history := <-read; len(history) != 1 || history[0].Content != "old" {
+		t.Fatalf("Expected the history read before the delete, got %v", history)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("Expected the read not to cache the deleted history, got %d conversations", cache.Len())
+	}
+}
+
+func TestMemoryConnectorCopiesParts(t *testing.T) {
+	connector := NewMemoryConnector()
+	defer connector.Close()
+
+	id := uuid.New()
+	saved := []message.Message{{
+		Role:      role.User,
+		Parts:     []message.ContentPart{message.TextPart("hello"), message.ImageURLPart("https://example.com/a.png", "")},
+		ToolCalls: []message.ToolCall{{ID: "call_1", Function: message.FunctionCall{Name: "lookup", Arguments: "{}"}}},
+	}}
+	_ = connector.SaveHistory(id, saved)
+	saved[0].Parts[0].Text = "changed"
+	saved[0].Parts[1].ImageURL.URL = "changed"
+	saved[0].ToolCalls[0].Function.Arguments = "changed"
+
+	history, _ := connector.HistoryById(id)
+	if history[0].Parts[0].Text != "hello" || history[0].Parts[1].ImageURL.URL != "https://example.com/a.png" ||
+		history[0].ToolCalls[0].Function.Arguments != "{}" {
+		t.Fatalf("Expected the stored history not to share the parts and the tool calls, got %+v", history)
+	}
+	history[0].Parts[0].Text = "changed"
+	if history, _ = connector.HistoryById(id); history[0].Parts[0].Text != "hello" {
+		t.Fatalf("Expected the read history to be a copy, got %+v", history)
+	}
+}
@@ -0,0 +1,192 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+
+	"github.com/google/uuid"
+)
+
+// SQLDialect adapts the queries and the schema of the SQLConnector to a database
+type SQLDialect string
+
+const (
+	DialectPostgres SQLDialect = "postgres"
+	DialectSQLite   SQLDialect = "sqlite"
+)
+
+const (
+	messagesTable   = "conversation_messages"
+	migrationsTable = "conversation_schema_migrations"
+)
+
+// sqlMigrations are applied in order, each version once, the position in the list is the version
+var sqlMigrations = []map[SQLDialect][]string{
+	{
+		DialectPostgres: {
+			`CREATE TABLE IF NOT EXISTS conversation_messages (
+				conversation_id UUID NOT NULL,
+				seq INTEGER NOT NULL,
+				role VARCHAR(32) NOT NULL,
+				message JSONB NOT NULL,
+				PRIMARY KEY (conversation_id, seq)
+			)`,
+		},
+		DialectSQLite: {
+			`CREATE TABLE IF NOT EXISTS conversation_messages (
+				conversation_id TEXT NOT NULL,
+				seq INTEGER NOT NULL,
+				role TEXT NOT NULL,
+				message TEXT NOT NULL,
+				PRIMARY KEY (conversation_id, seq)
+			)`,
+		},
+	},
+}
+
+// SQLConnector keeps a conversation as one row per message, ordered by sequence, in a database/sql database.
+// The schema is created by MigrateSQL
+type SQLConnector struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+// NewSQLConnector uses the database, whose schema must be migrated with MigrateSQL
+func NewSQLConnector(db *sql.DB, dialect SQLDialect) (*SQLConnector, error) {
+	if err := dialect.validate(); err != nil {
+		return nil, err
+	}
+	return &SQLConnector{db: db, dialect: dialect}, nil
+}
+
+// MigrateSQL creates or upgrades the schema of the SQLConnector, the migrations already applied are skipped.
+// On Postgres, concurrent migrations wait for each other
+func MigrateSQL(ctx context.Context, db *sql.DB, dialect SQLDialect) error {
+	if err := dialect.validate(); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTable+` (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", migrationsTable, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if dialect == DialectPostgres {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE `+migrationsTable+` IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM `+migrationsTable).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqlMigrations); version++ {
+		for _, statement := range sqlMigrations[version][dialect] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, dialect.rebind(`INSERT INTO `+migrationsTable+` (version) VALUES (?)`), version+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *SQLConnector) HistoryById(id uuid.UUID) ([]message.Message, error) {
+	rows, err := c.db.QueryContext(context.Background(),
+		c.dialect.rebind(`SELECT message FROM `+messagesTable+` WHERE conversation_id = ? ORDER BY seq`), id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []message.Message
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var m message.Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		history = append(history, m)
+	}
+	return history, rows.Err()
+}
+
+func (c *SQLConnector) DeleteHistory(id uuid.UUID) error {
+	_, err := c.db.ExecContext(context.Background(),
+		c.dialect.rebind(`DELETE FROM `+messagesTable+` WHERE conversation_id = ?`), id.String())
+	return err
+}
+
+// SaveHistory replaces the messages of the conversation in one transaction. The messages are upserted, so
+// concurrent saves of a new conversation wait for each other on its rows instead of failing on the primary key
+func (c *SQLConnector) SaveHistory(id uuid.UUID, history []message.Message) error {
+	ctx := context.Background()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.PrepareContext(ctx, c.dialect.rebind(`INSERT INTO `+messagesTable+` (conversation_id, seq, role, message) VALUES (?, ?, ?, ?)
+		ON CONFLICT (conversation_id, seq) DO UPDATE SET role = excluded.role, message = excluded.message`))
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	for seq, m := range history {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if _, err := upsert.ExecContext(ctx, id.String(), seq, m.Role, string(data)); err != nil {
+			return err
+		}
+	}
+	// the messages beyond the saved history belong to a longer history saved before
+	_, err = tx.ExecContext(ctx, c.dialect.rebind(`DELETE FROM `+messagesTable+` WHERE conversation_id = ? AND seq >= ?`), id.String(), len(history))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d SQLDialect) validate() error {
+	switch d {
+	case DialectPostgres, DialectSQLite:
+		return nil
+	}
+	return fmt.Errorf("unsupported SQL dialect %q", d)
+}
+
+// rebind replaces the ? placeholders of the query with the numbered placeholders of Postgres
+func (d SQLDialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+func newSQLiteConnector(t *testing.T) (*SQLConnector, *sql.DB) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	for i := 0; i < 2; i++ {
+		if err := MigrateSQL(context.Background(), db, DialectSQLite); err != nil {
+			t.Fatalf("Migration %d failed: %v", i+1, err)
+		}
+	}
+	connector, err := NewSQLConnector(db, DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connector, db
+}
+
+func TestSQLConnector(t *testing.T) {
+	connector, db := newSQLiteConnector(t)
+	var _ Connector = connector
+
+	var version int
+	if err := db.QueryRow(`SELECT MAX(version) FROM ` + migrationsTable).Scan(&version); err != nil || version != len(sqlMigrations) {
+		t.Fatalf("Expected version %d, got %d, %v", len(sqlMigrations), version, err)
+	}
+
+	id, other := uuid.New(), uuid.New()
+	history, err := connector.HistoryById(id)
+	if err != nil || history != nil {
+		t.Fatalf("Expected no history, got %v, %v", history, err)
+	}
+	saved := []message.Message{
+		{Role: role.System, Content: "You are an assistant"},
+		{Role: role.User, Parts: []message.ContentPart{message.TextPart("look"), message.ImageURLPart("https://example.com/a.png", message.DetailLow)}},
+		{Role: role.Assistant, ToolCalls: []message.ToolCall{{ID: "1", Type: "function", Function: message.FunctionCall{Name: "f", Arguments: "{}"}}}},
+		{Role: "tool", Content: "result", ToolCallID: "1"},
+	}
+	if err := connector.SaveHistory(id, saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := connector.SaveHistory(other, saved[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if history, err = connector.HistoryById(id); err != nil || !reflect.DeepEqual(history, saved) {
+		t.Fatalf("Expected %+v, got %+v, %v", saved, history, err)
+	}
+
+	// a shorter history replaces every message
+	if err := connector.SaveHistory(id, saved[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if history, err = connector.HistoryById(id); err != nil || !reflect.DeepEqual(history, saved[:2]) {
+		t.Fatalf("Expected %+v, got %+v, %v", saved[:2], history, err)
+	}
+
+	if err := connector.DeleteHistory(id); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ = connector.HistoryById(id); history != nil {
+		t.Fatalf("Expected the history to be deleted, got %v", history)
+	}
+	if history, _ = connector.HistoryById(other); len(history) != 1 {
+		t.Fatalf("Expected the other conversation to be kept, got %v", history)
+	}
+}
+
+func TestSQLConnectorConcurrentFirstSaves(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "conversations.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := MigrateSQL(context.Background(), db, DialectSQLite); err != nil {
+		t.Fatal(err)
+	}
+	connector, err := NewSQLConnector(db, DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every save of the new conversation succeeds, one of them is kept whole
+	id := uuid.New()
+	histories := make([][]message.Message, 8)
+	var wg sync.WaitGroup
+	for i := range histories {
+		for j := 0; j <= i; j++ {
+			histories[i] = append(histories[i], message.Message{Role: role.User, Content: fmt.Sprintf("save %d", i)})
+		}
+		wg.Add(1)
+		go func(history []message.Message) {
+			defer wg.Done()
+			if err := connector.SaveHistory(id, history); err != nil {
+				t.Error(err)
+			}
+		}(histories[i])
+	}
+	wg.Wait()
+	history, err := connector.HistoryById(id)
+	if err != nil || len(history) == 0 || !reflect.DeepEqual(history, histories[len(history)-1]) {
+		t.Fatalf("Expected one of the saved histories, got %+v, %v", history, err)
+	}
+}
+
+func TestSQLDialect(t *testing.T) {
+	query := `INSERT INTO t (a, b) VALUES (?, ?)`
+	if actual := DialectPostgres.rebind(query); actual != `INSERT INTO t (a, b) VALUES ($1, $2)` {
+		t.Fatalf("Unexpected Postgres query %s", actual)
+	}
+	if actual := DialectSQLite.rebind(query); actual != query {
+		t.Fatalf("Unexpected SQLite query %s", actual)
+	}
+	if _, err := NewSQLConnector(nil, "oracle"); err == nil {
+		t.Fatal("Expected an unsupported dialect to fail")
+	}
+}
@@ -0,0 +1,61 @@
+package contentfilter
+
+const (
+	SeveritySafe   = "safe"
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Results are the Azure OpenAI content filter annotations of a prompt or a completion
+type Results struct {
+	Hate                  *SeverityResult  `json:"hate,omitempty"`
+	SelfHarm              *SeverityResult  `json:"self_harm,omitempty"`
+	Sexual                *SeverityResult  `json:"sexual,omitempty"`
+	Violence              *SeverityResult  `json:"violence,omitempty"`
+	Profanity             *DetectionResult `json:"profanity,omitempty"`
+	Jailbreak             *DetectionResult `json:"jailbreak,omitempty"`
+	ProtectedMaterialText *DetectionResult `json:"protected_material_text,omitempty"`
+	ProtectedMaterialCode *DetectionResult `json:"protected_material_code,omitempty"`
+	Error                 *Error           `json:"error,omitempty"`
+}
+
+type SeverityResult struct {
+	Filtered bool   `json:"filtered"`
+	Severity string `json:"severity,omitempty"`
+}
+
+type DetectionResult struct {
+	Filtered bool `json:"filtered"`
+	Detected bool `json:"detected"`
+}
+
+// Error is set when the content filter could not evaluate the content
+type Error struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// PromptResults are the content filter results of the prompt at PromptIndex
+type PromptResults struct {
+	PromptIndex int      `json:"prompt_index"`
+	Results     *Results `json:"content_filter_results,omitempty"`
+}
+
+// Filtered reports whether any category caused the content to be filtered
+func (r *Results) Filtered() bool {
+	if r == nil {
+		return false
+	}
+	for _, s := range []*SeverityResult{r.Hate, r.SelfHarm, r.Sexual, r.Violence} {
+		if s != nil && s.Filtered {
+			return true
+		}
+	}
+	for _, d := range []*DetectionResult{r.Profanity, r.Jailbreak, r.ProtectedMaterialText, r.ProtectedMaterialCode} {
+		if d != nil && d.Filtered {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,33 @@
 package maskedSecret
 
+// MaskedSecret is a masked line, or the masked lines of a multiline secret. Line is 0-based for single line
+// secrets and the 1-based first line for multiline ones, use the findings for precise positions
 type MaskedSecret struct {
 	Masked string `json:"masked"`
 	Secret string `json:"secret"`
 	Line   int    `json:"line"`
 }
 
+// Finding is a secret hidden by the masking. Lines and columns are 1-based and columns count bytes, offsets are
+// 0-based byte offsets in the original content. The end positions are exclusive, so the secret is
+// content[StartOffset:EndOffset]. The secret itself is not included, so findings can be shared safely
+type Finding struct {
+	RuleID      string  `json:"ruleId"`
+	RuleName    string  `json:"ruleName"`
+	Severity    string  `json:"severity"`
+	Category    string  `json:"category"`
+	StartLine   int     `json:"startLine"`
+	StartColumn int     `json:"startColumn"`
+	EndLine     int     `json:"endLine"`
+	EndColumn   int     `json:"endColumn"`
+	StartOffset int     `json:"startOffset"`
+	EndOffset   int     `json:"endOffset"`
+	Entropy     float64 `json:"entropy"`
+	Replacement string  `json:"replacement"`
+}
+
 type MaskedEntry struct {
 	MaskedSecrets []MaskedSecret `json:"maskedSecrets"`
 	MaskedFile    string         `json:"maskedFile"`
+	Findings      []Finding      `json:"findings,omitempty"`
 }
@@ -0,0 +1,85 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	PartText     = "text"
+	PartImageURL = "image_url"
+)
+
+// Detail levels of an image, a low detail image costs fewer tokens
+const (
+	DetailAuto = "auto"
+	DetailLow  = "low"
+	DetailHigh = "high"
+)
+
+// ContentPart is a piece of a multimodal message, either text or an image
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL is the URL of an image, or its data as a data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+func ImageURLPart(url, detail string) ContentPart {
+	return ContentPart{Type: PartImageURL, ImageURL: &ImageURL{URL: url, Detail: detail}}
+}
+
+// ImageDataPart embeds the image as a base64 data URL, mediaType is e.g. image/png
+func ImageDataPart(mediaType string, data []byte, detail string) ContentPart {
+	return ImageURLPart(fmt.Sprintf("data:%s;base64,%s", mediaType, base64.StdEncoding.EncodeToString(data)), detail)
+}
+
+// plainMessage has the fields of Message without its JSON methods
+type plainMessage Message
+
+// MarshalJSON writes the content as an array of parts when the message has parts, and as a string otherwise
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		return json.Marshal(plainMessage(m))
+	}
+	parts := m.Parts
+	if m.Content != "" {
+		parts = append([]ContentPart{TextPart(m.Content)}, parts...)
+	}
+	return json.Marshal(struct {
+		plainMessage
+		Content []ContentPart `json:"content"`
+	}{plainMessage(m), parts})
+}
+
+// UnmarshalJSON reads a content that is either a string, as in the history files of previous versions, or an array of parts
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		plainMessage
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message(raw.plainMessage)
+	content := bytes.TrimSpace(raw.Content)
+	switch {
+	case len(content) == 0 || bytes.Equal(content, []byte("null")):
+		return nil
+	case content[0] == '[':
+		return json.Unmarshal(content, &m.Parts)
+	default:
+		return json.Unmarshal(content, &m.Content)
+	}
+}
@@ -0,0 +1,90 @@
+package message_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/connector"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
+)
+
+func TestContentPartsJSON(t *testing.T) {
+	m := message.Message{Role: role.User, Parts: []message.ContentPart{
+		message.TextPart("What does this diagram show?"),
+		message.ImageURLPart("https://example.com/diagram.png", message.DetailHigh),
+		message.ImageDataPart("image/png", []byte{0x89, 'P', 'N', 'G'}, message.DetailLow),
+	}}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"role":"user","content":[{"type":"text","text":"What does this diagram show?"},` +
+		`{"type":"image_url","image_url":{"url":"https://example.com/diagram.png","detail":"high"}},` +
+		`{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw==","detail":"low"}}]}`
+	if string(data) != expected {
+		t.Fatalf("Expected %s, got %s", expected, data)
+	}
+	var decoded message.Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Fatalf("Expected %+v, got %+v", m, decoded)
+	}
+
+	data, err = json.Marshal(message.Message{Role: role.User, Content: "plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"role":"user","content":"plain"}` {
+		t.Fatalf("Expected a string content, got %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"role":"assistant","content":null,"tool_calls":[{"id":"1","type":"function","function":{"name":"f","arguments":"{}"}}]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Content != "" || decoded.Parts != nil || len(decoded.ToolCalls) != 1 {
+		t.Fatalf("Unexpected message %+v", decoded)
+	}
+}
+
+func TestFileSystemConnectorContentParts(t *testing.T) {
+	dir := t.TempDir()
+	fs := connector.NewFileSystemConnector(dir)
+
+	// a history written before content parts existed
+	legacy := uuid.New()
+	if err := os.MkdirAll(filepath.Join(dir, "cx-gpt"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	legacyHistory := `[{"role":"system","content":"You are an assistant"},{"role":"user","content":"hello"}]`
+	if err := os.WriteFile(filepath.Join(dir, "cx-gpt", legacy.String()), []byte(legacyHistory), 0644); err != nil {
+		t.Fatal(err)
+	}
+	history, err := fs.HistoryById(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Content != "hello" || history[1].Parts != nil {
+		t.Fatalf("Unexpected legacy history %+v", history)
+	}
+
+	id := uuid.New()
+	history = append(history, message.Message{Role: role.User, Parts: []message.ContentPart{
+		message.TextPart("and this?"), message.ImageURLPart("https://example.com/a.png", ""),
+	}})
+	if err := fs.SaveHistory(id, history); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := fs.HistoryById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, history) {
+		t.Fatalf("Expected %+v, got %+v", history, loaded)
+	}
+}
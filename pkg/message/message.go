@@ -3,4 +3,47 @@ package message
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Parts make a multimodal content of text and images, a Content set too is sent as a first text part
+	Parts      []ContentPart `json:"-"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type Delta struct {
+	Index     int             `json:"-"`
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a tool call, the arguments of a call arrive split over several deltas with the same index
+type ToolCallDelta struct {
+	Index    int          `json:"index"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// TurnEnd returns the index after the turn starting at start, an assistant message requesting tool calls
+// and the tool messages answering it form a single turn that must be kept or dropped together
+func TurnEnd(messages []Message, start int) int {
+	end := start + 1
+	if len(messages[start].ToolCalls) == 0 {
+		return end
+	}
+	for end < len(messages) && messages[end].ToolCallID != "" {
+		end++
+	}
+	return end
 }
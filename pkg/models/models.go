@@ -1,10 +1,15 @@
 package models
 
+import "strings"
+
 const (
 	GPT4               = "gpt-4"
 	GPT432K            = "gpt-4-32k"
 	GPT432K0314        = "gpt-4-32k-0314"
 	GPT40314           = "gpt-4-0314"
+	GPT4Turbo          = "gpt-4-turbo"
+	GPT4o              = "gpt-4o"
+	GPT4oMini          = "gpt-4o-mini"
 	GPT3Dot5Turbo      = "gpt-3.5-turbo"
 	GPT3Dot5Turbo0301  = "gpt-3.5-turbo-0301"
 	GPT3TextDavinci001 = "text-davinci-001"
@@ -12,3 +17,43 @@ const (
 	GPT3TextDavinci003 = "text-davinci-003"
 	DefaultModel       = GPT4
 )
+
+const (
+	EncodingCl100kBase = "cl100k_base"
+	EncodingO200kBase  = "o200k_base"
+)
+
+var contextWindows = map[string]int{
+	GPT4:              8192,
+	GPT40314:          8192,
+	GPT432K:           32768,
+	GPT432K0314:       32768,
+	GPT4Turbo:         128000,
+	GPT4o:             128000,
+	GPT4oMini:         128000,
+	GPT3Dot5Turbo:     16385,
+	GPT3Dot5Turbo0301: 4096,
+}
+
+// ContextWindow returns the number of tokens the model accepts for prompt and completion, 0 when unknown
+func ContextWindow(model string) int {
+	if window, ok := contextWindows[model]; ok {
+		return window
+	}
+	// dated snapshots such as gpt-4o-2024-08-06 share the window of their family
+	family := ""
+	for name := range contextWindows {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(family) {
+			family = name
+		}
+	}
+	return contextWindows[family]
+}
+
+// Encoding returns the name of the BPE encoding used by the model
+func Encoding(model string) string {
+	if strings.HasPrefix(model, GPT4o) {
+		return EncodingO200kBase
+	}
+	return EncodingCl100kBase
+}
@@ -0,0 +1,130 @@
+// Package secrets exposes the secret detection rules used to mask content before it is sent to the model
+package secrets
+
+import (
+	"io"
+
+	"github.com/Checkmarx/gen-ai-wrapper/internal/secrets"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
+)
+
+type (
+	RulePack        = secrets.RulePack
+	Rule            = secrets.SecretRule
+	AllowRule       = secrets.AllowRule
+	Entropy         = secrets.Entropy
+	Multiline       = secrets.Multiline
+	RuleSet         = secrets.RuleSet
+	RuleProblem     = secrets.RuleProblem
+	ValidationError = secrets.ValidationError
+	ImportWarning   = secrets.ImportWarning
+	Scanner         = secrets.Scanner
+	Finding         = maskedSecret.Finding
+	SarifReport     = secrets.SarifReport
+	SarifLog        = secrets.SarifLog
+	PIICategory     = secrets.PIICategory
+	StreamMasker    = secrets.StreamMasker
+)
+
+// PII categories, the placeholders of their findings are named after them
+const (
+	PIIEmail      = secrets.PIIEmail
+	PIIPhone      = secrets.PIIPhone
+	PIIIPv4       = secrets.PIIIPv4
+	PIIIPv6       = secrets.PIIIPv6
+	PIICreditCard = secrets.PIICreditCard
+	PIIIBAN       = secrets.PIIIBAN
+	PIINationalID = secrets.PIINationalID
+
+	// CategorySecret is the category of the findings of the secret rules
+	CategorySecret = secrets.CategorySecret
+)
+
+// Targets of an allow rule, the part of a match its regex is matched against
+const (
+	AllowTargetLine   = secrets.AllowTargetLine
+	AllowTargetMatch  = secrets.AllowTargetMatch
+	AllowTargetSecret = secrets.AllowTargetSecret
+)
+
+// DefaultSeverity is the severity of the findings of rules without one
+const DefaultSeverity = secrets.DefaultSeverity
+
+// ParseRulePack decodes a JSON rule pack, which has the format of the embedded rules plus a disabledRules list of rule IDs
+func ParseRulePack(data []byte) (*RulePack, error) {
+	return secrets.ParseRulePack(data)
+}
+
+// LoadRulePack reads a JSON rule pack from a file
+func LoadRulePack(path string) (*RulePack, error) {
+	return secrets.LoadRulePack(path)
+}
+
+// NewRuleSet merges the packs into the embedded rules, an invalid rule fails with a *ValidationError listing every problem
+func NewRuleSet(packs ...*RulePack) (*RuleSet, error) {
+	return secrets.NewRuleSet(packs...)
+}
+
+// DefaultRuleSet returns the embedded rules
+func DefaultRuleSet() (*RuleSet, error) {
+	return secrets.DefaultRuleSet()
+}
+
+// ImportGitleaks converts a gitleaks TOML configuration into a rule pack, constructs that cannot be
+// honoured exactly, such as path or commit allowlists, are reported as warnings
+func ImportGitleaks(data []byte) (*RulePack, []ImportWarning, error) {
+	return secrets.ImportGitleaks(data)
+}
+
+// LoadGitleaks reads and converts a gitleaks TOML configuration, such as .gitleaks.toml
+func LoadGitleaks(path string) (*RulePack, []ImportWarning, error) {
+	return secrets.LoadGitleaks(path)
+}
+
+// NewScanner compiles the keyword prefilter of the rules, the scanner is safe for concurrent use. Nil rules
+// return the DefaultScanner
+func NewScanner(rules *RuleSet) *Scanner {
+	return secrets.NewScanner(rules)
+}
+
+// DefaultScanner returns the scanner of the embedded rules
+func DefaultScanner() (*Scanner, error) {
+	return secrets.DefaultScanner()
+}
+
+// Scan masks the content with the embedded rules and returns the findings, with the rule, severity, range,
+// entropy and replacement of every hidden secret
+func Scan(content string) (*maskedSecret.MaskedEntry, error) {
+	scanner, err := secrets.DefaultScanner()
+	if err != nil {
+		return nil, err
+	}
+	return scanner.Scan(content)
+}
+
+// NewSarifReport collects findings into a SARIF 2.1.0 log, with a rule catalog listing every rule of the RuleSet,
+// or of the embedded rules when it is nil. The fingerprints of the secrets are an HMAC with fingerprintKey, keep
+// the key secret and reuse it to track secrets across reports, an empty key gets a random one per report
+func NewSarifReport(rules *RuleSet, fingerprintKey []byte) (*SarifReport, error) {
+	return secrets.NewSarifReport(rules, fingerprintKey)
+}
+
+// PIICategories returns every PII category
+func PIICategories() []PIICategory {
+	return secrets.PIICategories()
+}
+
+// WithPII returns the rules, or the embedded rules when nil, extended with the PII detectors of the categories
+func WithPII(rules *RuleSet, categories ...PIICategory) (*RuleSet, error) {
+	return rules.WithPII(categories...)
+}
+
+// MaskReader writes the content of r masked with the embedded rules to w, reading it chunk by chunk.
+// Use Scanner.NewStreamMasker for other rules, chunk sizes or to receive the findings
+func MaskReader(w io.Writer, r io.Reader) error {
+	scanner, err := secrets.DefaultScanner()
+	if err != nil {
+		return err
+	}
+	return scanner.NewStreamMasker().Mask(w, r)
+}
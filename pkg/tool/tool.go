@@ -0,0 +1,79 @@
+package tool
+
+import (
+	"encoding/json"
+)
+
+const TypeFunction = "function"
+
+const (
+	ChoiceAuto     = "auto"
+	ChoiceNone     = "none"
+	ChoiceRequired = "required"
+)
+
+type Tool struct {
+	Type     string   `json:"type"`
+	Function Function `json:"function"`
+}
+
+type Function struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// NewFunction describes a local function the model may call, parameters is a JSON schema object
+func NewFunction(name, description string, parameters json.RawMessage) Tool {
+	return Tool{
+		Type: TypeFunction,
+		Function: Function{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+// Choice controls whether the model calls a tool, either one of the Choice modes or a specific function
+type Choice struct {
+	Mode     string
+	Function string
+}
+
+func ChooseFunction(name string) *Choice {
+	return &Choice{Function: name}
+}
+
+func ChooseMode(mode string) *Choice {
+	return &Choice{Mode: mode}
+}
+
+func (c Choice) MarshalJSON() ([]byte, error) {
+	if c.Function == "" {
+		return json.Marshal(c.Mode)
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": TypeFunction,
+		"function": map[string]string{
+			"name": c.Function,
+		},
+	})
+}
+
+func (c *Choice) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Mode); err == nil {
+		return nil
+	}
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	c.Mode = ""
+	c.Function = named.Function.Name
+	return nil
+}
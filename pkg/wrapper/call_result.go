@@ -0,0 +1,51 @@
+package wrapper
+
+import (
+	"github.com/Checkmarx/gen-ai-wrapper/internal"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/contentfilter"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+)
+
+// CallResult is the reply of the model along with the usage reported for it
+type CallResult struct {
+	Messages         []message.Message `json:"messages"`
+	FinishReasons    []string          `json:"finishReasons"`
+	Model            string            `json:"model"`
+	ResponseID       string            `json:"responseId"`
+	RequestID        string            `json:"requestId"`
+	PromptTokens     int               `json:"promptTokens"`
+	CompletionTokens int               `json:"completionTokens"`
+	TotalTokens      int               `json:"totalTokens"`
+	// ContentFilterResults holds the Azure OpenAI annotations of each choice, nil for other backends
+	ContentFilterResults []*contentfilter.Results      `json:"contentFilterResults,omitempty"`
+	PromptFilterResults  []contentfilter.PromptResults `json:"promptFilterResults,omitempty"`
+}
+
+func newCallResult(response *internal.ChatCompletionResponse) *CallResult {
+	result := &CallResult{
+		Model:               response.Model,
+		ResponseID:          response.ID,
+		PromptTokens:        response.Usage.PromptTokens,
+		CompletionTokens:    response.Usage.CompletionTokens,
+		TotalTokens:         response.Usage.TotalTokens,
+		PromptFilterResults: response.PromptFilterResults,
+	}
+	filtered := false
+	for _, c := range response.Choices {
+		result.Messages = append(result.Messages, c.Message)
+		result.FinishReasons = append(result.FinishReasons, c.FinishReason)
+		result.ContentFilterResults = append(result.ContentFilterResults, c.ContentFilterResults)
+		filtered = filtered || c.ContentFilterResults != nil
+	}
+	if !filtered {
+		result.ContentFilterResults = nil
+	}
+	return result
+}
+
+// addUsage accounts for the tokens of an earlier call made for the same result
+func (r *CallResult) addUsage(earlier *CallResult) {
+	r.PromptTokens += earlier.PromptTokens
+	r.CompletionTokens += earlier.CompletionTokens
+	r.TotalTokens += earlier.TotalTokens
+}
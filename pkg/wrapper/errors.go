@@ -0,0 +1,6 @@
+package wrapper
+
+import "github.com/Checkmarx/gen-ai-wrapper/internal"
+
+// ResponseError is returned when the model answers with an error status, use errors.As to inspect it
+type ResponseError = internal.ResponseError
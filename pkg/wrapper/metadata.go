@@ -0,0 +1,21 @@
+package wrapper
+
+import (
+	"context"
+
+	"github.com/Checkmarx/gen-ai-wrapper/internal"
+)
+
+// Metadata identifies a call to the proxy, which uses it for per-tenant routing and auditing
+type Metadata = internal.ChatMetaData
+
+// WithMetadata returns a context carrying the metadata of the calls made with it,
+// a request id is generated for calls without one
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return internal.WithMetaData(ctx, metadata)
+}
+
+// MetadataFromContext returns the metadata carried by the context, empty when there is none
+func MetadataFromContext(ctx context.Context) Metadata {
+	return internal.MetaDataFromContext(ctx)
+}
@@ -0,0 +1,155 @@
+package wrapper
+
+import (
+	"github.com/Checkmarx/gen-ai-wrapper/internal"
+	"github.com/Checkmarx/gen-ai-wrapper/internal/secrets"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/tool"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultCompletionReserve is the number of tokens of the context window kept free for the completion
+const DefaultCompletionReserve = 1024
+
+const DefaultAzureAPIVersion = internal.DefaultAzureAPIVersion
+
+// Option customizes a wrapper when it is created
+type Option func(*options)
+
+type options struct {
+	config            internal.Config
+	contextWindow     int
+	completionReserve int
+	defaults          completion.Options
+	metadata          Metadata
+	reversibleMasking bool
+	rules             *secrets.RuleSet
+	pii               []secrets.PIICategory
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		config: internal.Config{
+			RetryPolicy: retry.DefaultPolicy(),
+		},
+		completionReserve: DefaultCompletionReserve,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithRetryPolicy sets how calls failing with a rate limit, server or transport error are retried
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(o *options) {
+		o.config.RetryPolicy = policy
+	}
+}
+
+// WithProvider selects a registered provider by name instead of by the scheme of the endpoint
+func WithProvider(name string) Option {
+	return func(o *options) {
+		o.config.Provider = name
+	}
+}
+
+// WithAzure calls an Azure OpenAI deployment with api-key auth, the endpoint is the resource endpoint.
+// An empty deployment defaults to the model name and an empty apiVersion to DefaultAzureAPIVersion
+func WithAzure(deployment, apiVersion string) Option {
+	return func(o *options) {
+		o.config.Azure = &internal.AzureConfig{
+			Deployment: deployment,
+			APIVersion: apiVersion,
+		}
+	}
+}
+
+// WithTLS secures the connection to the grpc proxy, mutual TLS when a client certificate is set
+func WithTLS(config TLSConfig) Option {
+	return func(o *options) {
+		o.config.TLS = &config
+	}
+}
+
+// WithPerRPCCredentials attaches the credentials to every call to the grpc proxy
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(o *options) {
+		o.config.PerRPCCredentials = creds
+	}
+}
+
+// WithBearerToken authenticates every call to the grpc proxy with the token, which requires TLS
+func WithBearerToken(token string) Option {
+	return WithPerRPCCredentials(internal.BearerToken(token))
+}
+
+// WithContextWindow sets the number of tokens the model accepts, for models or deployments unknown to the models package
+func WithContextWindow(tokens int) Option {
+	return func(o *options) {
+		o.contextWindow = tokens
+	}
+}
+
+// WithCompletionReserve sets the number of tokens of the context window kept free for the completion
+func WithCompletionReserve(tokens int) Option {
+	return func(o *options) {
+		o.completionReserve = tokens
+	}
+}
+
+// WithTools sets the tools the model may call, replies requesting a call hold the tool calls instead of content
+func WithTools(tools ...tool.Tool) Option {
+	return func(o *options) {
+		o.defaults.Tools = tools
+	}
+}
+
+// WithToolChoice controls whether the model calls a tool, by default the model decides
+func WithToolChoice(choice *tool.Choice) Option {
+	return func(o *options) {
+		o.defaults.ToolChoice = choice
+	}
+}
+
+// WithDefaultOptions sets the completion options of every call, options passed to a call take precedence
+func WithDefaultOptions(defaults completion.Options) Option {
+	return func(o *options) {
+		o.defaults = o.defaults.Merge(defaults)
+	}
+}
+
+// WithDefaultMetadata sets the tenant and origin of every call, metadata in the call context takes precedence
+func WithDefaultMetadata(metadata Metadata) Option {
+	return func(o *options) {
+		o.metadata = metadata
+	}
+}
+
+// WithReversibleMasking masks every secret with its own placeholder, such as <masked:AWS_SECRET_KEY_1>,
+// and restores the placeholders found in the reply, so the secrets never leave the process
+func WithReversibleMasking() Option {
+	return func(o *options) {
+		o.reversibleMasking = true
+	}
+}
+
+// WithRuleSet masks secrets with the rules, e.g. the embedded rules extended with company rule packs.
+// Build the rule set with secrets.NewRuleSet of the pkg/secrets package
+func WithRuleSet(rules *secrets.RuleSet) Option {
+	return func(o *options) {
+		o.rules = rules
+	}
+}
+
+// WithPIIMasking also masks personal data of the categories, all of them when none is given, with placeholders
+// naming the category, e.g. <masked:EMAIL>. Use the categories of the pkg/secrets package
+func WithPIIMasking(categories ...secrets.PIICategory) Option {
+	return func(o *options) {
+		if len(categories) == 0 {
+			categories = secrets.PIICategories()
+		}
+		o.pii = categories
+	}
+}
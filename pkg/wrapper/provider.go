@@ -0,0 +1,37 @@
+package wrapper
+
+import "github.com/Checkmarx/gen-ai-wrapper/internal"
+
+// Names of the built-in providers, each is also accepted as the scheme of the endpoint, e.g. grpc+tls://proxy:443
+const (
+	ProviderOpenAI  = internal.ProviderOpenAI
+	ProviderAzure   = internal.ProviderAzure
+	ProviderGRPC    = internal.ProviderGRPC
+	ProviderGRPCTLS = internal.ProviderGRPCTLS
+	ProviderOllama  = internal.ProviderOllama
+)
+
+// Backend sends chat completion requests to a model, the wrappers handle masking, history and context trimming
+type Backend = internal.Wrapper
+
+type (
+	BackendConfig          = internal.Config
+	AzureConfig            = internal.AzureConfig
+	TLSConfig              = internal.TLSConfig
+	ChatCompletionRequest  = internal.ChatCompletionRequest
+	ChatCompletionResponse = internal.ChatCompletionResponse
+	ChatCompletionChunk    = internal.ChatCompletionChunk
+	Choice                 = internal.Choice
+	ChunkChoice            = internal.ChunkChoice
+	Usage                  = internal.Usage
+	ChunkHandler           = internal.ChunkHandler
+)
+
+// ProviderFactory creates the Backend of a provider from the endpoint, api key and drop length given to the wrapper
+type ProviderFactory = internal.ProviderFactory
+
+// RegisterProvider makes a backend selectable with WithProvider or as the scheme of the endpoint,
+// registering an existing name replaces it. It is meant to be called from an init function
+func RegisterProvider(name string, factory ProviderFactory) {
+	internal.RegisterProvider(name, factory)
+}
@@ -0,0 +1,142 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+)
+
+type echoBackend struct {
+	endPoint string
+}
+
+func (b *echoBackend) Call(request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return b.CallContext(context.Background(), request)
+}
+
+func (b *echoBackend) CallContext(_ context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	last := request.Messages[len(request.Messages)-1]
+	return &ChatCompletionResponse{
+		ID:    b.endPoint,
+		Model: request.Model,
+		Choices: []Choice{{
+			Message:      message.Message{Role: role.Assistant, Content: last.Content},
+			FinishReason: "stop",
+		}},
+	}, nil
+}
+
+func (b *echoBackend) CallStream(ctx context.Context, request ChatCompletionRequest, _ ChunkHandler) (*ChatCompletionResponse, error) {
+	return b.CallContext(ctx, request)
+}
+
+func (b *echoBackend) SetupCall([]message.Message) {}
+
+func (b *echoBackend) Close() error {
+	return nil
+}
+
+func init() {
+	RegisterProvider("echo", func(endPoint, _ string, _ int, _ BackendConfig) (Backend, error) {
+		return &echoBackend{endPoint: endPoint}, nil
+	})
+}
+
+func TestRegisteredProvider(t *testing.T) {
+	for _, c := range []struct {
+		endPoint string
+		opts     []Option
+	}{
+		{"echo://local", nil},
+		{"https://echo.example.com", []Option{WithProvider("echo")}},
+	} {
+		wrapper, err := NewStatelessWrapper(c.endPoint, apikey, models.GPT4, 4, 0, c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := wrapper.CallWithResult(context.Background(), nil, []message.Message{{Role: role.User, Content: "hello"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ResponseID != c.endPoint || result.Messages[0].Content != "hello" {
+			t.Fatalf("Expected the registered provider to answer, got %+v", result)
+		}
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	_, err := NewStatelessWrapper("https://example.com", apikey, models.GPT4, 4, 0, WithProvider("missing"))
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("Expected an unknown provider error, got %v", err)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","model":"llama3","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	endPoint := strings.Replace(server.URL, "http://", "ollama://", 1)
+	wrapper, err := NewStatelessWrapper(endPoint, "", "llama3", 4, 0, WithContextWindow(8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := wrapper.Call(nil, []message.Message{{Role: role.User, Content: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response[0].Content != "ok" {
+		t.Fatalf("Unexpected response %v", response)
+	}
+}
+
+type metadataBackend struct {
+	echoBackend
+	received []Metadata
+}
+
+func (b *metadataBackend) CallContext(ctx context.Context, request ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	b.received = append(b.received, MetadataFromContext(ctx))
+	return b.echoBackend.CallContext(ctx, request)
+}
+
+func TestCallMetadata(t *testing.T) {
+	backend := &metadataBackend{}
+	RegisterProvider("metadata", func(string, string, int, BackendConfig) (Backend, error) {
+		return backend, nil
+	})
+	wrapper, err := NewStatelessWrapper("metadata://local", apikey, models.GPT4, 4, 0, WithDefaultMetadata(Metadata{TenantID: "default", Origin: "cli"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMessages := []message.Message{{Role: role.User, Content: "hello"}}
+
+	result, err := wrapper.CallWithResult(context.Background(), nil, newMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithMetadata(context.Background(), Metadata{TenantID: "tenant-1", RequestID: "request-1"})
+	_, err = wrapper.CallWithResult(ctx, nil, newMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generated := backend.received[0]
+	if generated.TenantID != "default" || generated.Origin != "cli" || generated.RequestID == "" || generated.RequestID != result.RequestID {
+		t.Fatalf("Expected the default metadata with a generated request id, got %+v and result %q", generated, result.RequestID)
+	}
+	if given := backend.received[1]; given != (Metadata{TenantID: "tenant-1", RequestID: "request-1", Origin: "cli"}) {
+		t.Fatalf("Expected the context metadata to take precedence, got %+v", given)
+	}
+}
@@ -1,6 +1,11 @@
 package wrapper
 
 import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/connector"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
@@ -10,8 +15,13 @@ import (
 type StatefulWrapper interface {
 	GenerateId() uuid.UUID
 	Call(uuid.UUID, []message.Message) ([]message.Message, error)
+	CallContext(context.Context, uuid.UUID, []message.Message, ...completion.Options) ([]message.Message, error)
+	CallStream(context.Context, uuid.UUID, []message.Message, DeltaHandler, ...completion.Options) ([]message.Message, error)
+	CallWithResult(context.Context, uuid.UUID, []message.Message, ...completion.Options) (*CallResult, error)
+	CallStreamWithResult(context.Context, uuid.UUID, []message.Message, DeltaHandler, ...completion.Options) (*CallResult, error)
 	SetupCall([]message.Message)
 	MaskSecrets(fileContent string) (*maskedSecret.MaskedEntry, error)
+	MaskReader(w io.Writer, r io.Reader) error
 }
 
 type StatefulWrapperImpl struct {
@@ -19,8 +29,8 @@ type StatefulWrapperImpl struct {
 	StatelessWrapper
 }
 
-func NewStatefulWrapperNew(storageConnector connector.Connector, endpoint, apiKey, model string, dropLen, limit int) (StatefulWrapper, error) {
-	statelessWrapper, err := NewStatelessWrapper(endpoint, apiKey, model, dropLen, limit)
+func NewStatefulWrapperNew(storageConnector connector.Connector, endpoint, apiKey, model string, dropLen, limit int, opts ...Option) (StatefulWrapper, error) {
+	statelessWrapper, err := NewStatelessWrapper(endpoint, apiKey, model, dropLen, limit, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -31,8 +41,8 @@ func NewStatefulWrapperNew(storageConnector connector.Connector, endpoint, apiKe
 }
 
 // NewStatefulWrapper will be deprecated in the future
-func NewStatefulWrapper(storageConnector connector.Connector, apiKey, model string, dropLen, limit int) StatefulWrapper {
-	statelessWrapper, err := NewStatefulWrapperNew(storageConnector, OpenAiEndPoint, apiKey, model, dropLen, limit)
+func NewStatefulWrapper(storageConnector connector.Connector, apiKey, model string, dropLen, limit int, opts ...Option) StatefulWrapper {
+	statelessWrapper, err := NewStatefulWrapperNew(storageConnector, OpenAiEndPoint, apiKey, model, dropLen, limit, opts...)
 	if err != nil {
 		return nil
 	}
@@ -48,32 +58,121 @@ func (w *StatefulWrapperImpl) GenerateId() uuid.UUID {
 }
 
 func (w *StatefulWrapperImpl) Call(id uuid.UUID, newMessages []message.Message) ([]message.Message, error) {
+	return w.CallContext(context.Background(), id, newMessages)
+}
+
+func (w *StatefulWrapperImpl) CallContext(ctx context.Context, id uuid.UUID, newMessages []message.Message,
+	opts ...completion.Options) ([]message.Message, error) {
+	result, err := w.CallWithResult(ctx, id, newMessages, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+// CallStream streams the reply to onDelta and saves it to the history once the stream has ended
+func (w *StatefulWrapperImpl) CallStream(ctx context.Context, id uuid.UUID, newMessages []message.Message, onDelta DeltaHandler,
+	opts ...completion.Options) ([]message.Message, error) {
+	result, err := w.CallStreamWithResult(ctx, id, newMessages, onDelta, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+func (w *StatefulWrapperImpl) CallWithResult(ctx context.Context, id uuid.UUID, newMessages []message.Message,
+	opts ...completion.Options) (*CallResult, error) {
+	return w.call(ctx, id, newMessages, nil, opts)
+}
+
+func (w *StatefulWrapperImpl) CallStreamWithResult(ctx context.Context, id uuid.UUID, newMessages []message.Message, onDelta DeltaHandler,
+	opts ...completion.Options) (*CallResult, error) {
+	if onDelta == nil {
+		return nil, errMissingDeltaHandler
+	}
+	return w.call(ctx, id, newMessages, onDelta, opts)
+}
+
+func (w *StatefulWrapperImpl) call(ctx context.Context, id uuid.UUID, newMessages []message.Message, onDelta DeltaHandler,
+	opts []completion.Options) (*CallResult, error) {
 	var err error
 	var history []message.Message
-	var response []message.Message
+	var result *CallResult
 
 	history, err = w.connector.HistoryById(id)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err = w.StatelessWrapper.Call(history, newMessages)
+	if onDelta == nil {
+		result, err = w.StatelessWrapper.CallWithResult(ctx, history, newMessages, opts...)
+	} else {
+		result, err = w.StatelessWrapper.CallStreamWithResult(ctx, history, newMessages, onDelta, opts...)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if len(response) != 1 {
-		panic(response)
+	if len(result.Messages) != 1 {
+		return nil, fmt.Errorf("history can only be saved for a single choice, got %d", len(result.Messages))
 	}
 
 	history = append(history, newMessages...)
-	history = append(history, response[0])
+	history = append(history, result.Messages[0])
 
-	err = w.connector.SaveHistory(id, history)
+	switch store := w.connector.(type) {
+	case connector.MetadataUpdater:
+		err = store.SaveHistoryWithMetadata(id, history, func(metadata *connector.ConversationMetadata) {
+			w.updateMetadata(ctx, metadata, result)
+		})
+	case connector.ConversationStore:
+		if err = store.SaveHistory(id, history); err == nil {
+			err = w.saveMetadata(ctx, store, id, result)
+		}
+	default:
+		err = w.connector.SaveHistory(id, history)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return response, nil
+	return result, nil
+}
+
+// saveMetadata records the call in the metadata of the conversation, for stores saving it apart from the history
+func (w *StatefulWrapperImpl) saveMetadata(ctx context.Context, store connector.ConversationStore, id uuid.UUID, result *CallResult) error {
+	metadata, err := store.MetadataById(id)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = &connector.ConversationMetadata{ID: id}
+	}
+	w.updateMetadata(ctx, metadata, result)
+	return store.SaveMetadata(*metadata)
+}
+
+// updateMetadata records the tenant, the model and the usage of the call in the metadata of the conversation
+func (w *StatefulWrapperImpl) updateMetadata(ctx context.Context, metadata *connector.ConversationMetadata, result *CallResult) {
+	if metadata.TenantID == "" {
+		metadata.TenantID = w.tenantID(ctx)
+	}
+	if result.Model != "" {
+		metadata.Model = result.Model
+	}
+	metadata.PromptTokens += result.PromptTokens
+	metadata.CompletionTokens += result.CompletionTokens
+	metadata.TotalTokens += result.TotalTokens
+}
+
+// tenantID is the tenant of the call context, or the default tenant of the wrapper
+func (w *StatefulWrapperImpl) tenantID(ctx context.Context) string {
+	if tenantID := MetadataFromContext(ctx).TenantID; tenantID != "" {
+		return tenantID
+	}
+	if impl, ok := w.StatelessWrapper.(*StatelessWrapperImpl); ok {
+		return impl.metadata.TenantID
+	}
+	return ""
 }
 
 func (w *StatefulWrapperImpl) MaskSecrets(fileContent string) (*maskedSecret.MaskedEntry, error) {
@@ -1,14 +1,23 @@
 package wrapper
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/Checkmarx/gen-ai-wrapper/internal"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/connector"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/tool"
 )
 
 type Config struct {
@@ -121,3 +130,190 @@ func TestMaskSecrets(t *testing.T) {
 		t.Logf("secret: %s, masked: %s, line: %d\n", entries.MaskedSecrets[0].Secret, entries.MaskedSecrets[0].Masked, entries.MaskedSecrets[0].Line)
 	}
 }
+
+func TestCallStreamSavesHistory(t *testing.T) {
+	server := newSSEServer(t, streamedChunks)
+	defer server.Close()
+
+	storage := connector.NewFileSystemConnector(t.TempDir())
+	wrapper, err := NewStatefulWrapperNew(storage, server.URL, apikey, models.GPT4, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := wrapper.GenerateId()
+	_, err = wrapper.CallStream(context.Background(), id, []message.Message{{
+		Role:    role.User,
+		Content: fmt.Sprintf(userInput, userQuestions[0]),
+	}}, func(message.Delta) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := storage.HistoryById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1].Content != streamedReply {
+		t.Fatalf("Unexpected history %v", history)
+	}
+}
+
+func TestCallToolTurnSavesHistory(t *testing.T) {
+	var requests []internal.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request internal.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":null,"tool_calls":[`+
+				`{"id":"call_1","type":"function","function":{"name":"get_query_description","arguments":"{\"query\":\"ALB Deletion Protection Disabled\"}"}}]},`+
+				`"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-2","choices":[{"message":{"role":"assistant","content":"Enable deletion protection."},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	storage := connector.NewFileSystemConnector(t.TempDir())
+	getQueryDescription := tool.NewFunction("get_query_description", "Returns the description of a KICS query",
+		json.RawMessage(`{"type":"object","properties":{"query":{"type":"string"}},"required":["query"]}`))
+	wrapper, err := NewStatefulWrapperNew(storage, server.URL, apikey, models.GPT4, 4, 0, WithTools(getQueryDescription))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := wrapper.GenerateId()
+	response, err := wrapper.Call(id, []message.Message{{Role: role.User, Content: userQuestions[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests[0].Tools) != 1 || requests[0].Tools[0].Function.Name != "get_query_description" {
+		t.Fatalf("Expected tools in the request, got %+v", requests[0].Tools)
+	}
+	if len(response[0].ToolCalls) != 1 {
+		t.Fatalf("Expected a tool call, got %+v", response[0])
+	}
+
+	call := response[0].ToolCalls[0]
+	_, err = wrapper.Call(id, []message.Message{{Role: role.Tool, ToolCallID: call.ID, Content: "Deletion protection should be enabled"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := requests[1].Messages
+	if len(sent) != 3 || sent[1].ToolCalls[0].ID != call.ID || sent[2].Role != role.Tool || sent[2].ToolCallID != call.ID {
+		t.Fatalf("Expected the tool turn in the request, got %+v", sent)
+	}
+
+	history, err := storage.HistoryById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 || history[1].ToolCalls[0].Function.Arguments != call.Function.Arguments || history[2].ToolCallID != call.ID {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+}
+
+func TestCallSavesConversationMetadata(t *testing.T) {
+	server := newSSEServer(t, streamedChunks)
+	defer server.Close()
+
+	storage := connector.NewFileSystemConnector(t.TempDir())
+	wrapper, err := NewStatefulWrapperNew(storage, server.URL, apikey, models.GPT4, 4, 0, WithDefaultMetadata(Metadata{TenantID: "default"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := wrapper.GenerateId()
+	ctx := WithMetadata(context.Background(), Metadata{TenantID: "tenant-1"})
+	for i := 0; i < 2; i++ {
+		_, err = wrapper.CallStream(ctx, id, []message.Message{{Role: role.User, Content: userQuestions[i]}}, func(message.Delta) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conversations, _, err := storage.(connector.ConversationStore).ListConversations(connector.ConversationFilter{TenantID: "tenant-1"}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 {
+		t.Fatalf("Expected 1 conversation, got %+v", conversations)
+	}
+	metadata := conversations[0]
+	if metadata.ID != id || metadata.Title != userQuestions[0] || metadata.Model != "gpt-4" || metadata.MessageCount != 4 ||
+		metadata.PromptTokens != 24 || metadata.CompletionTokens != 8 || metadata.TotalTokens != 32 {
+		t.Fatalf("Unexpected metadata %+v", metadata)
+	}
+}
+
+func TestCallMasksRestoredToolCallArguments(t *testing.T) {
+	const secret = "hunter2hunter2"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), secret) {
+			t.Errorf("Request %d sent the secret: %s", requests, body)
+		}
+		if requests == 1 {
+			_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":null,"tool_calls":[`+
+				`{"id":"call_1","type":"function","function":{"name":"set_password","arguments":"{\"password\":\"<masked:GENERIC_PASSWORD_1>\"}"}}]},`+
+				`"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-2","choices":[{"message":{"role":"assistant","content":"The password is set."},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	storage := connector.NewFileSystemConnector(t.TempDir())
+	wrapper, err := NewStatefulWrapperNew(storage, server.URL, apikey, models.GPT4, 4, 0, WithReversibleMasking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := wrapper.GenerateId()
+	response, err := wrapper.Call(id, []message.Message{{Role: role.User, Content: "Set password=" + secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := response[0].ToolCalls[0]
+	if !strings.Contains(call.Function.Arguments, secret) {
+		t.Fatalf("Expected the secret restored in the arguments, got %q", call.Function.Arguments)
+	}
+
+	// the reply saved with the restored secret is masked again on the next turn
+	_, err = wrapper.Call(id, []message.Message{{Role: role.Tool, ToolCallID: call.ID, Content: "done"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestCallStreamEndedEarlyIsNotSaved(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// the connection closes before the [DONE] event
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", streamedChunks[0])
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", streamedChunks[1])
+	}))
+	defer server.Close()
+
+	storage := connector.NewFileSystemConnector(t.TempDir())
+	wrapper, err := NewStatefulWrapperNew(storage, server.URL, apikey, models.GPT4, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := wrapper.GenerateId()
+	_, err = wrapper.CallStream(context.Background(), id, []message.Message{{Role: role.User, Content: userQuestions[0]}},
+		func(message.Delta) error {
+			return nil
+		})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected the cut stream to fail, got %v", err)
+	}
+	if history, _ := storage.HistoryById(id); history != nil {
+		t.Fatalf("Expected no history for a cut reply, got %v", history)
+	}
+}
@@ -1,60 +1,178 @@
 package wrapper
 
 import (
+	"context"
 	"errors"
+	"io"
+	"strings"
 
 	"github.com/Checkmarx/gen-ai-wrapper/internal"
 	"github.com/Checkmarx/gen-ai-wrapper/internal/secrets"
+	"github.com/Checkmarx/gen-ai-wrapper/internal/tokens"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/maskedSecret"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/google/uuid"
 )
 
 const OpenAiEndPoint = "https://api.openai.com/v1/chat/completions"
 
+var errMissingDeltaHandler = errors.New("delta handler is required for streaming")
+
 type StatelessWrapper interface {
 	Call([]message.Message, []message.Message) ([]message.Message, error)
+	CallContext(context.Context, []message.Message, []message.Message, ...completion.Options) ([]message.Message, error)
+	CallStream(context.Context, []message.Message, []message.Message, DeltaHandler, ...completion.Options) ([]message.Message, error)
+	CallWithResult(context.Context, []message.Message, []message.Message, ...completion.Options) (*CallResult, error)
+	CallStreamWithResult(context.Context, []message.Message, []message.Message, DeltaHandler, ...completion.Options) (*CallResult, error)
 	SetupCall([]message.Message)
 	MaskSecrets(fileContent string) (*maskedSecret.MaskedEntry, error)
+	MaskReader(w io.Writer, r io.Reader) error
 }
 
+// DeltaHandler is called with every piece of content received while a reply is streamed
+type DeltaHandler func(delta message.Delta) error
+
 type StatelessWrapperImpl struct {
-	wrapper internal.Wrapper
-	model   string
-	dropLen int
-	limit   int
+	wrapper           internal.Wrapper
+	model             string
+	dropLen           int
+	limit             int
+	contextWindow     int
+	completionReserve int
+	defaults          completion.Options
+	metadata          Metadata
+	reversibleMasking bool
+	scanner           *secrets.Scanner
+	setupMessages     []message.Message
 }
 
-func NewStatelessWrapper(endPoint, apiKey, model string, dropLen, limit int) (StatelessWrapper, error) {
+func NewStatelessWrapper(endPoint, apiKey, model string, dropLen, limit int, opts ...Option) (StatelessWrapper, error) {
 	if model == "" {
 		model = models.DefaultModel
 	}
-	wrapper, err := internal.NewWrapperFactory(endPoint, apiKey, dropLen)
+	o := newOptions(opts)
+	o.config.Model = model
+	rules := o.rules
+	if o.pii != nil {
+		var err error
+		if rules, err = rules.WithPII(o.pii...); err != nil {
+			return nil, err
+		}
+	}
+	scanner, err := rules.Scanner()
 	if err != nil {
 		return nil, err
 	}
+	wrapper, err := internal.NewWrapperFactory(endPoint, apiKey, dropLen, o.config)
+	if err != nil {
+		return nil, err
+	}
+	contextWindow := o.contextWindow
+	if contextWindow == 0 {
+		contextWindow = models.ContextWindow(model)
+	}
 	return &StatelessWrapperImpl{
-		wrapper,
-		model,
-		dropLen,
-		limit,
+		wrapper:           wrapper,
+		model:             model,
+		dropLen:           dropLen,
+		limit:             limit,
+		contextWindow:     contextWindow,
+		completionReserve: o.completionReserve,
+		defaults:          o.defaults,
+		metadata:          o.metadata,
+		reversibleMasking: o.reversibleMasking,
+		scanner:           scanner,
 	}, nil
 }
 
 func (w *StatelessWrapperImpl) SetupCall(setupMessages []message.Message) {
+	w.setupMessages = setupMessages
 	w.wrapper.SetupCall(setupMessages)
 }
 
 func (w *StatelessWrapperImpl) Call(history []message.Message, newMessages []message.Message) ([]message.Message, error) {
+	return w.CallContext(context.Background(), history, newMessages)
+}
+
+func (w *StatelessWrapperImpl) CallContext(ctx context.Context, history []message.Message, newMessages []message.Message,
+	opts ...completion.Options) ([]message.Message, error) {
+	result, err := w.CallWithResult(ctx, history, newMessages, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+func (w *StatelessWrapperImpl) CallStream(ctx context.Context, history []message.Message, newMessages []message.Message, onDelta DeltaHandler,
+	opts ...completion.Options) ([]message.Message, error) {
+	result, err := w.CallStreamWithResult(ctx, history, newMessages, onDelta, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result.Messages, nil
+}
+
+func (w *StatelessWrapperImpl) CallWithResult(ctx context.Context, history []message.Message, newMessages []message.Message,
+	opts ...completion.Options) (*CallResult, error) {
+	return w.call(w.withMetadata(ctx), history, newMessages, nil, w.mergeOptions(opts))
+}
+
+func (w *StatelessWrapperImpl) CallStreamWithResult(ctx context.Context, history []message.Message, newMessages []message.Message, onDelta DeltaHandler,
+	opts ...completion.Options) (*CallResult, error) {
+	if onDelta == nil {
+		return nil, errMissingDeltaHandler
+	}
+	return w.call(w.withMetadata(ctx), history, newMessages, onDelta, w.mergeOptions(opts))
+}
+
+// withMetadata fills the metadata missing from the context with the wrapper defaults and a new request id
+func (w *StatelessWrapperImpl) withMetadata(ctx context.Context) context.Context {
+	metadata := MetadataFromContext(ctx)
+	if metadata.TenantID == "" {
+		metadata.TenantID = w.metadata.TenantID
+	}
+	if metadata.Origin == "" {
+		metadata.Origin = w.metadata.Origin
+	}
+	if metadata.RequestID == "" {
+		metadata.RequestID = uuid.NewString()
+	}
+	return WithMetadata(ctx, metadata)
+}
+
+func (w *StatelessWrapperImpl) mergeOptions(opts []completion.Options) completion.Options {
+	merged := w.defaults
+	for _, o := range opts {
+		merged = merged.Merge(o)
+	}
+	return merged
+}
+
+func (w *StatelessWrapperImpl) call(ctx context.Context, history []message.Message, newMessages []message.Message, onDelta DeltaHandler,
+	options completion.Options) (*CallResult, error) {
+	var masker *secrets.Masker
+	if w.reversibleMasking {
+		masker = w.scanner.NewMasker()
+	}
 	var conversation []message.Message
 	userMessageCount := 0
 	for _, m := range append(history, newMessages...) {
-		maskedContent, _, err := secrets.MaskSecrets(m.Content)
+		maskedContent, _, err := w.mask(masker, m.Content)
 		if err != nil {
 			return nil, err
 		}
-		conversation = append(conversation, message.Message{Role: m.Role, Content: maskedContent})
+		m.Content = maskedContent
+		if m.Parts, err = w.maskParts(masker, m.Parts); err != nil {
+			return nil, err
+		}
+		// the history keeps the secrets restored in the arguments of earlier replies
+		if m.ToolCalls, err = w.maskToolCalls(masker, m.ToolCalls); err != nil {
+			return nil, err
+		}
+		conversation = append(conversation, m)
 		if m.Role == role.User {
 			userMessageCount++
 		}
@@ -64,38 +182,199 @@ func (w *StatelessWrapperImpl) Call(history []message.Message, newMessages []mes
 		return nil, errors.New("user message limit exceeded")
 	}
 
+	conversation, err := w.fitContextWindow(conversation, len(history), options.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+
 	requestBody := internal.ChatCompletionRequest{
 		Model:    w.model,
 		Messages: conversation,
+		Options:  options,
 	}
 
-	response, err := w.wrapper.Call(requestBody)
+	var response *internal.ChatCompletionResponse
+	if onDelta == nil {
+		response, err = w.wrapper.CallContext(ctx, requestBody)
+	} else {
+		response, err = w.stream(ctx, requestBody, onDelta, masker)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var responseMessages []message.Message
-
+	result := newCallResult(response)
+	for i := range result.Messages {
+		result.Messages[i] = restoreMessage(masker, result.Messages[i])
+	}
+	result.RequestID = MetadataFromContext(ctx).RequestID
 	for _, c := range response.Choices {
-		if c.FinishReason == internal.FinishReasonLength {
-			return w.Call(history[w.dropLen:], newMessages)
+		// a streamed reply was already delivered, so it is returned as is
+		if c.FinishReason == internal.FinishReasonLength && onDelta == nil {
+			if trimmed, ok := internal.DropOldest(history, w.dropLen, len(history)); ok {
+				retried, err := w.call(ctx, trimmed, newMessages, nil, options)
+				if err != nil {
+					return nil, err
+				}
+				retried.addUsage(result)
+				return retried, nil
+			}
 		}
-		responseMessages = append(responseMessages, message.Message{
-			Role:    c.Message.Role,
-			Content: c.Message.Content,
-		})
 	}
 
-	return responseMessages, nil
+	return result, nil
 }
 
-func (w *StatelessWrapperImpl) MaskSecrets(fileContent string) (*maskedSecret.MaskedEntry, error) {
-	maskedFile, maskedSecrets, err := secrets.MaskSecrets(fileContent)
+func (w *StatelessWrapperImpl) mask(masker *secrets.Masker, content string) (string, []maskedSecret.MaskedSecret, error) {
+	if masker != nil {
+		return masker.Mask(content)
+	}
+	return w.scanner.Mask(content)
+}
+
+// maskParts masks the text parts and the image URLs, with their query strings, in a copy of the parts. The
+// images embedded as data URLs are sent as they are
+func (w *StatelessWrapperImpl) maskParts(masker *secrets.Masker, parts []message.ContentPart) ([]message.ContentPart, error) {
+	if len(parts) == 0 {
+		return parts, nil
+	}
+	masked := make([]message.ContentPart, len(parts))
+	for i, part := range parts {
+		var err error
+		switch {
+		case part.Type == message.PartText:
+			if part.Text, _, err = w.mask(masker, part.Text); err != nil {
+				return nil, err
+			}
+		case part.ImageURL != nil && !strings.HasPrefix(part.ImageURL.URL, "data:"):
+			imageURL := *part.ImageURL
+			if imageURL.URL, _, err = w.mask(masker, imageURL.URL); err != nil {
+				return nil, err
+			}
+			part.ImageURL = &imageURL
+		}
+		masked[i] = part
+	}
+	return masked, nil
+}
+
+// maskToolCalls masks the arguments in a copy of the tool calls
+func (w *StatelessWrapperImpl) maskToolCalls(masker *secrets.Masker, toolCalls []message.ToolCall) ([]message.ToolCall, error) {
+	if len(toolCalls) == 0 {
+		return toolCalls, nil
+	}
+	masked := make([]message.ToolCall, len(toolCalls))
+	for i, call := range toolCalls {
+		var err error
+		if call.Function.Arguments, _, err = w.mask(masker, call.Function.Arguments); err != nil {
+			return nil, err
+		}
+		masked[i] = call
+	}
+	return masked, nil
+}
+
+// stream delivers the deltas of the reply with their placeholders restored
+func (w *StatelessWrapperImpl) stream(ctx context.Context, requestBody internal.ChatCompletionRequest, onDelta DeltaHandler,
+	masker *secrets.Masker) (*internal.ChatCompletionResponse, error) {
+	restorers := map[[2]int]*secrets.Restorer{}
+	var keys [][2]int
+	restore := func(choice, call int, piece string) string {
+		key := [2]int{choice, call}
+		if _, ok := restorers[key]; !ok {
+			restorers[key] = masker.NewRestorer()
+			keys = append(keys, key)
+		}
+		return restorers[key].Write(piece)
+	}
+	response, err := w.wrapper.CallStream(ctx, requestBody, func(chunk *internal.ChatCompletionChunk) error {
+		for _, c := range chunk.Choices {
+			delta := c.Delta
+			delta.Index = c.Index
+			delta.Content = restore(c.Index, -1, delta.Content)
+			delta.ToolCalls = nil
+			for _, call := range c.Delta.ToolCalls {
+				call.Function.Arguments = restore(c.Index, call.Index, call.Function.Arguments)
+				if call.ID != "" || call.Function.Name != "" || call.Function.Arguments != "" {
+					delta.ToolCalls = append(delta.ToolCalls, call)
+				}
+			}
+			if delta.Role == "" && delta.Content == "" && len(delta.ToolCalls) == 0 {
+				continue
+			}
+			if err := onDelta(delta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	var maskedEntry = maskedSecret.MaskedEntry{}
-	maskedEntry.MaskedFile = maskedFile
-	maskedEntry.MaskedSecrets = maskedSecrets
-	return &maskedEntry, nil
+	// deliver the text held back in case it looked like the start of a placeholder
+	for _, key := range keys {
+		rest := restorers[key].Flush()
+		if rest == "" {
+			continue
+		}
+		delta := message.Delta{Index: key[0]}
+		if key[1] < 0 {
+			delta.Content = rest
+		} else {
+			delta.ToolCalls = []message.ToolCallDelta{{Index: key[1], Function: message.FunctionCall{Arguments: rest}}}
+		}
+		if err := onDelta(delta); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// restoreMessage replaces the placeholders of the reply with the secrets they stand for
+func restoreMessage(masker *secrets.Masker, m message.Message) message.Message {
+	if masker == nil {
+		return m
+	}
+	m.Content = masker.Restore(m.Content)
+	if m.ToolCalls != nil {
+		toolCalls := make([]message.ToolCall, len(m.ToolCalls))
+		for i, call := range m.ToolCalls {
+			call.Function.Arguments = masker.Restore(call.Function.Arguments)
+			toolCalls[i] = call
+		}
+		m.ToolCalls = toolCalls
+	}
+	return m
+}
+
+// fitContextWindow drops the oldest history until the conversation leaves room for the completion,
+// system messages and the new messages are never dropped
+func (w *StatelessWrapperImpl) fitContextWindow(conversation []message.Message, historyLen int, maxTokens *int) ([]message.Message, error) {
+	if w.contextWindow <= 0 {
+		return conversation, nil
+	}
+	counter, err := tokens.NewCounter(w.model)
+	if err != nil {
+		return nil, err
+	}
+	budget := w.contextWindow - w.completionReserve
+	if maxTokens != nil {
+		budget = w.contextWindow - *maxTokens
+	}
+	if len(w.setupMessages) > 0 {
+		budget -= counter.CountMessages(w.setupMessages)
+	}
+	return counter.Fit(conversation, budget, func(index int, m message.Message) bool {
+		return index >= historyLen || m.Role == role.System
+	})
+}
+
+func (w *StatelessWrapperImpl) MaskSecrets(fileContent string) (*maskedSecret.MaskedEntry, error) {
+	return w.scanner.Scan(fileContent)
+}
+
+// MaskReader writes the content of r masked with the rules of the wrapper to w, reading it chunk by chunk
+// so large files and logs are masked with bounded memory
+func (w *StatelessWrapperImpl) MaskReader(dst io.Writer, r io.Reader) error {
+	return w.scanner.NewStreamMasker().Mask(dst, r)
 }
@@ -1,12 +1,23 @@
 package wrapper
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/completion"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/contentfilter"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/message"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/models"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/retry"
 	"github.com/Checkmarx/gen-ai-wrapper/pkg/role"
+	"github.com/Checkmarx/gen-ai-wrapper/pkg/secrets"
 )
 
 func TestCallGPT(t *testing.T) {
@@ -62,3 +73,430 @@ func TestCallEmptyApiKey(t *testing.T) {
 		t.Fatal("Call succeeded without API key")
 	}
 }
+
+func TestCallContextCancelled(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = wrapper.CallContext(ctx, nil, []message.Message{{
+		Role:    role.User,
+		Content: fmt.Sprintf(userInput, userQuestions[0]),
+	}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestCallStream(t *testing.T) {
+	server := newSSEServer(t, streamedChunks)
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deltas []string
+	response, err := wrapper.CallStream(context.Background(), nil, []message.Message{{
+		Role:    role.User,
+		Content: fmt.Sprintf(userInput, userQuestions[0]),
+	}}, func(delta message.Delta) error {
+		deltas = append(deltas, delta.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 3 || deltas[1] != "Enable " {
+		t.Fatalf("Unexpected deltas %q", deltas)
+	}
+	if len(response) != 1 || response[0].Role != role.Assistant || response[0].Content != streamedReply {
+		t.Fatalf("Unexpected response %v", response)
+	}
+}
+
+func TestCallRetryRateLimited(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("x-ratelimit-reset-requests", "10ms")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithRetryPolicy(retry.Policy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Second,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := wrapper.Call(nil, []message.Message{{Role: role.User, Content: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || response[0].Content != "ok" {
+		t.Fatalf("Expected a single retry, attempts: %d, response: %v", attempts, response)
+	}
+}
+
+func TestCallFitsContextWindow(t *testing.T) {
+	var sent []message.Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []message.Message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		sent = request.Messages
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithContextWindow(300), WithCompletionReserve(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []message.Message{{Role: role.System, Content: systemInput}}
+	for _, q := range userQuestions {
+		history = append(history, message.Message{Role: role.User, Content: q}, message.Message{Role: role.Assistant, Content: q})
+	}
+	_, err = wrapper.Call(history, []message.Message{{Role: role.User, Content: "How can I fix this issue?"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) >= len(history)+1 || sent[0].Content != systemInput || sent[len(sent)-1].Content != "How can I fix this issue?" {
+		t.Fatalf("Expected the oldest history to be trimmed, sent %d messages", len(sent))
+	}
+}
+
+func TestCallContextLengthExceededWithoutDropLen(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, `{"error":{"message":"maximum context length exceeded","code":"context_length_exceeded"}}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, "custom-model", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wrapper.Call([]message.Message{{Role: role.User, Content: "q1"}}, []message.Message{{Role: role.User, Content: "q2"}})
+	if err == nil || attempts != 1 {
+		t.Fatalf("Expected a single failed attempt, attempts: %d, err: %v", attempts, err)
+	}
+}
+
+func TestCallOptions(t *testing.T) {
+	var sent map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithDefaultOptions(completion.Options{
+		Temperature: completion.Ptr(0.7),
+		Seed:        completion.Ptr(7),
+		Stop:        []string{"<|IAC_QUESTION_END|>"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wrapper.CallContext(context.Background(), nil, []message.Message{{Role: role.User, Content: "hello"}}, completion.Options{
+		Temperature: completion.Ptr(0.0),
+		MaxTokens:   completion.Ptr(50),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent["temperature"] != 0.0 || sent["seed"] != 7.0 || sent["max_tokens"] != 50.0 || sent["stop"] == nil {
+		t.Fatalf("Unexpected options in request %v", sent)
+	}
+	if _, ok := sent["top_p"]; ok {
+		t.Fatalf("Unset options should be omitted %v", sent)
+	}
+}
+
+func TestCallWithResultSumsUsage(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-4-0613","choices":[{"message":{"role":"assistant","content":"cut"},"finish_reason":"length"}],`+
+				`"usage":{"prompt_tokens":100,"completion_tokens":10,"total_tokens":110}}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-2","model":"gpt-4-0613","choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":60,"completion_tokens":5,"total_tokens":65}}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []message.Message{
+		{Role: role.User, Content: "first"},
+		{Role: role.Assistant, Content: "first answer"},
+	}
+	result, err := wrapper.CallWithResult(context.Background(), history, []message.Message{{Role: role.User, Content: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("Expected the call to be repeated after a length finish, got %d attempts", attempts)
+	}
+	if result.ResponseID != "chatcmpl-2" || result.Model != "gpt-4-0613" || len(result.FinishReasons) != 1 || result.FinishReasons[0] != "stop" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if result.PromptTokens != 160 || result.CompletionTokens != 15 || result.TotalTokens != 175 {
+		t.Fatalf("Expected usage of both calls to be summed, got %+v", result)
+	}
+	if len(result.Messages) != 1 || result.Messages[0].Content != "ok" {
+		t.Fatalf("Unexpected messages %v", result.Messages)
+	}
+}
+
+func TestCallStreamWithResult(t *testing.T) {
+	server := newSSEServer(t, streamedChunks)
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := wrapper.CallStreamWithResult(context.Background(), nil, []message.Message{{Role: role.User, Content: "hello"}},
+		func(delta message.Delta) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResponseID != "chatcmpl-1" || result.Model != models.GPT4 || result.FinishReasons[0] != "stop" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if result.PromptTokens != 12 || result.CompletionTokens != 4 || result.TotalTokens != 16 {
+		t.Fatalf("Unexpected usage %+v", result)
+	}
+}
+
+func TestCallAzure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt-4-prod/chat/completions" || r.URL.Query().Get("api-version") != DefaultAzureAPIVersion {
+			t.Errorf("Unexpected deployment URL %s", r.URL)
+		}
+		if r.Header.Get("api-key") != "azure-key" || r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected api-key auth, got headers %v", r.Header)
+		}
+		var request map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if request["messages"].([]interface{})[0].(map[string]interface{})["content"] == "filtered" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error":{"message":"The response was filtered","type":null,"param":"prompt","code":"content_filter","status":400,`+
+				`"innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":false,"severity":"safe"},`+
+				`"violence":{"filtered":true,"severity":"high"}}}}}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-4","prompt_filter_results":[{"prompt_index":0,"content_filter_results":`+
+			`{"hate":{"filtered":false,"severity":"safe"},"jailbreak":{"filtered":false,"detected":false}}}],`+
+			`"choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"},`+
+			`"content_filter_results":{"hate":{"filtered":false,"severity":"safe"},"violence":{"filtered":false,"severity":"low"}}}],`+
+			`"usage":{"prompt_tokens":9,"completion_tokens":1,"total_tokens":10}}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, "azure-key", models.GPT4, 4, 0, WithAzure("gpt-4-prod", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := wrapper.CallWithResult(context.Background(), nil, []message.Message{{Role: role.User, Content: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.PromptFilterResults) != 1 || result.PromptFilterResults[0].Results.Jailbreak == nil {
+		t.Fatalf("Expected prompt filter results, got %+v", result.PromptFilterResults)
+	}
+	if len(result.ContentFilterResults) != 1 || result.ContentFilterResults[0].Violence.Severity != contentfilter.SeverityLow ||
+		result.ContentFilterResults[0].Filtered() {
+		t.Fatalf("Unexpected content filter results %+v", result.ContentFilterResults)
+	}
+
+	_, err = wrapper.CallWithResult(context.Background(), nil, []message.Message{{Role: role.User, Content: "filtered"}})
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) || !responseError.ContentFilterResults.Filtered() {
+		t.Fatalf("Expected a content filter error, got %v", err)
+	}
+}
+
+func TestCallReversibleMasking(t *testing.T) {
+	const placeholder = "<masked:GENERIC_PASSWORD_1>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []message.Message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if content := request.Messages[0].Content; content != "password = "+placeholder {
+			t.Errorf("Expected the secret to be masked, got %q", content)
+		}
+		_, _ = fmt.Fprintf(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"Move %s to a vault"},"finish_reason":"stop"}]}`, placeholder)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithReversibleMasking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := wrapper.Call(nil, []message.Message{{Role: role.User, Content: `password = "hunter2hunter2"`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `Move "hunter2hunter2" to a vault`; response[0].Content != expected {
+		t.Fatalf("Expected %q, got %q", expected, response[0].Content)
+	}
+}
+
+func TestCallStreamReversibleMasking(t *testing.T) {
+	server := newSSEServer(t, []string{
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Move <mask"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"ed:GENERIC_PASS"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"WORD_1> away <"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	})
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithReversibleMasking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed string
+	response, err := wrapper.CallStream(context.Background(), nil, []message.Message{{Role: role.User, Content: `password = "hunter2hunter2"`}},
+		func(delta message.Delta) error {
+			streamed += delta.Content
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Move "hunter2hunter2" away <`
+	if streamed != expected || response[0].Content != expected {
+		t.Fatalf("Expected %q, got streamed %q and response %q", expected, streamed, response[0].Content)
+	}
+}
+
+func TestMaskSecretsWithRuleSet(t *testing.T) {
+	pack, err := secrets.ParseRulePack([]byte(`{"rules":[{"id":"acme-token","name":"ACME Token","regex":"acme_[0-9a-f]{32}"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := secrets.NewRuleSet(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := NewStatelessWrapper(OpenAiEndPoint, apikey, models.GPT4, 4, 0, WithRuleSet(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := wrapper.MaskSecrets("token acme_0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.MaskedFile != "token <masked>" {
+		t.Fatalf("Expected the rule pack to mask the token, got %q", entry.MaskedFile)
+	}
+}
+
+func TestCallPIIMasking(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []message.Message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if expected := "Mail <masked:EMAIL_1> from <masked:IPV4_1>, password = <masked:GENERIC_PASSWORD_1>"; request.Messages[0].Content != expected {
+			t.Errorf("Expected %q, got %q", expected, request.Messages[0].Content)
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"Ask <masked:EMAIL_1>"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithReversibleMasking(),
+		WithPIIMasking(secrets.PIIEmail, secrets.PIIIPv4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := wrapper.Call(nil, []message.Message{{Role: role.User,
+		Content: `Mail jane.doe@example.com from 203.0.113.7, password = "hunter2hunter2"`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Ask jane.doe@example.com"; response[0].Content != expected {
+		t.Fatalf("Expected %q, got %q", expected, response[0].Content)
+	}
+
+	if _, err := NewStatelessWrapper(server.URL, apikey, models.GPT4, 4, 0, WithPIIMasking("passport")); err == nil {
+		t.Fatal("Expected an unknown PII category to fail")
+	}
+}
+
+func TestMaskReader(t *testing.T) {
+	wrapper, err := NewStatelessWrapper(OpenAiEndPoint, apikey, models.GPT4, 4, 0, WithPIIMasking(secrets.PIIEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var masked strings.Builder
+	err = wrapper.MaskReader(&masked, strings.NewReader("owner jane@example.com\r\npassword = \"hunter2hunter2\"\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "owner <masked:EMAIL>\r\npassword = <masked>\r\n"; masked.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, masked.String())
+	}
+}
+
+func TestCallContentParts(t *testing.T) {
+	image := message.ImageURLPart("https://example.com/report.png?password=hunter2hunter2", message.DetailHigh)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Messages []message.Message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		parts := request.Messages[0].Parts
+		if len(parts) != 2 || parts[0].Text != "Explain password = <masked>" || parts[1].ImageURL == nil ||
+			parts[1].ImageURL.URL != "https://example.com/report.png?password=<masked>" {
+			t.Errorf("Expected the text part and the image URL to be masked, got %+v", parts)
+		}
+		_, _ = fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"A report"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	wrapper, err := NewStatelessWrapper(server.URL, apikey, models.GPT4o, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []message.ContentPart{message.TextPart(`Explain password = "hunter2hunter2"`), image}
+	response, err := wrapper.Call(nil, []message.Message{{Role: role.User, Parts: parts}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response[0].Content != "A report" || parts[0].Text != `Explain password = "hunter2hunter2"` ||
+		parts[1].ImageURL.URL != "https://example.com/report.png?password=hunter2hunter2" {
+		t.Fatalf("Unexpected response %+v or parts changed %+v", response, parts)
+	}
+}
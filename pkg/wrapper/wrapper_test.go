@@ -1,6 +1,13 @@
 package wrapper
 
-import "os"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
 
 var apikey = os.Getenv("GPT-APIKEY")
 
@@ -45,3 +52,32 @@ var userQuestions = []string{
 	"How can I validate that my fix is good?",
 	"What should I know to eliminate such results in the future?",
 }
+
+var streamedChunks = []string{
+	`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+	`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"index":0,"delta":{"content":"Enable "}}]}`,
+	`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"index":0,"delta":{"content":"deletion protection."}}]}`,
+	`{"id":"chatcmpl-1","model":"gpt-4","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	`{"id":"chatcmpl-1","model":"gpt-4","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":4,"total_tokens":16}}`,
+}
+
+const streamedReply = "Enable deletion protection."
+
+func newSSEServer(t *testing.T, chunks []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Stream bool `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Stream {
+			t.Errorf("Expected a streaming request, err: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		for _, chunk := range chunks {
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", chunk)
+			flusher.Flush()
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}